@@ -94,3 +94,254 @@ CREATE TABLE IF NOT EXISTS packages (
 
   FOREIGN KEY (shipping_id) REFERENCES shippings(shipping_id) ON DELETE CASCADE
 );
+
+CREATE TABLE IF NOT EXISTS product_dimensions (
+  shop TEXT NOT NULL,
+  product_id INTEGER NOT NULL,
+  width REAL NOT NULL,
+  height REAL NOT NULL,
+  length REAL NOT NULL,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (shop, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS variant_dimensions (
+  shop TEXT NOT NULL,
+  variant_id INTEGER NOT NULL,
+  product_id INTEGER NOT NULL,
+  width REAL,
+  height REAL,
+  length REAL,
+  grams INTEGER,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (shop, variant_id)
+);
+
+CREATE TABLE IF NOT EXISTS dimension_defaults (
+  shop TEXT PRIMARY KEY,
+  width REAL NOT NULL,
+  height REAL NOT NULL,
+  length REAL NOT NULL,
+  grams INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS dimension_issues (
+  shop TEXT NOT NULL,
+  product_id INTEGER NOT NULL,
+  variant_id INTEGER NOT NULL DEFAULT 0,
+  field TEXT NOT NULL,
+  message TEXT NOT NULL,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (shop, product_id, variant_id)
+);
+
+CREATE TABLE IF NOT EXISTS carrier_settings (
+  shop TEXT PRIMARY KEY,
+  client_code TEXT NOT NULL,
+  username TEXT NOT NULL,
+  password TEXT NOT NULL,
+  access_token TEXT NOT NULL DEFAULT '',
+  contract_home TEXT NOT NULL DEFAULT '',
+  contract_branch TEXT NOT NULL DEFAULT '',
+  contract_express TEXT NOT NULL DEFAULT '',
+  origin_branch TEXT NOT NULL DEFAULT '',
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rate_quotes (
+  quote_id INTEGER PRIMARY KEY,
+  shop TEXT NOT NULL,
+  service_code TEXT NOT NULL,
+  contract TEXT NOT NULL,
+  zip TEXT NOT NULL,
+  volume REAL NOT NULL,
+  kilos REAL NOT NULL,
+  peso_aforado TEXT NOT NULL,
+  total TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS boxes (
+  box_id INTEGER PRIMARY KEY,
+  shop TEXT NOT NULL,
+  name TEXT NOT NULL,
+  width REAL NOT NULL,
+  height REAL NOT NULL,
+  length REAL NOT NULL,
+  max_kilos REAL NOT NULL,
+  tare_kilos REAL NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_rules (
+  rule_id INTEGER PRIMARY KEY,
+  shop TEXT NOT NULL,
+  name TEXT NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 0,
+  action TEXT NOT NULL,
+  value REAL NOT NULL DEFAULT 0,
+
+  service_code TEXT,
+  province TEXT,
+  zip_from INTEGER,
+  zip_to INTEGER,
+  min_subtotal INTEGER,
+  max_subtotal INTEGER,
+  sku_prefix TEXT,
+
+  active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS fallback_settings (
+  shop TEXT PRIMARY KEY,
+  mode TEXT NOT NULL,
+  timeout_ms INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fallback_rates (
+  shop TEXT NOT NULL,
+  service_code TEXT NOT NULL,
+  province TEXT NOT NULL,
+  price INTEGER NOT NULL,
+
+  PRIMARY KEY (shop, service_code, province)
+);
+
+CREATE TABLE IF NOT EXISTS rate_cache (
+  cache_key TEXT PRIMARY KEY,
+  shop TEXT NOT NULL,
+  rate TEXT NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS delivery_settings (
+  shop TEXT PRIMARY KEY,
+  handling_days INTEGER NOT NULL DEFAULT 1,
+  cutoff TEXT NOT NULL DEFAULT '14:00'
+);
+
+CREATE TABLE IF NOT EXISTS transit_times (
+  transit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  shop TEXT NOT NULL,
+  service_code TEXT NOT NULL,
+  origin_province TEXT,
+  province TEXT,
+  zip_from INTEGER,
+  zip_to INTEGER,
+  min_days INTEGER NOT NULL,
+  max_days INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_transit_times_shop ON transit_times(shop, service_code);
+
+CREATE TABLE IF NOT EXISTS holidays (
+  date TEXT PRIMARY KEY,
+  name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS location_origins (
+  shop TEXT NOT NULL,
+  location_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  street TEXT,
+  number TEXT,
+  zip TEXT,
+  city TEXT,
+  province TEXT,
+  branch TEXT,
+
+  PRIMARY KEY (shop, location_id)
+);
+
+CREATE TABLE IF NOT EXISTS labels (
+  label_id INTEGER PRIMARY KEY,
+  shipping_id INTEGER NOT NULL,
+  package_number TEXT NOT NULL DEFAULT '',
+  content_type TEXT NOT NULL,
+  content BLOB NOT NULL,
+  sha256 TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE(shipping_id, package_number),
+  FOREIGN KEY (shipping_id) REFERENCES shippings(shipping_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shipping_dates (
+  shipping_id INTEGER PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+
+  FOREIGN KEY (shipping_id) REFERENCES shippings(shipping_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shipment_events (
+  event_id INTEGER PRIMARY KEY,
+  shipping_id INTEGER NOT NULL,
+  shipping_number TEXT NOT NULL,
+  state TEXT NOT NULL,
+  state_id INTEGER NOT NULL,
+  reason TEXT,
+  branch TEXT,
+  occurred_at DATETIME NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE(shipping_id, shipping_number, state_id, occurred_at),
+  FOREIGN KEY (shipping_id) REFERENCES shippings(shipping_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shipping_fulfillments (
+  shipping_id INTEGER PRIMARY KEY,
+  fulfillment_id TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (shipping_id) REFERENCES shippings(shipping_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_deliveries (
+  order_id INTEGER PRIMARY KEY,
+  delivered_at DATETIME NOT NULL,
+
+  FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS shipment_event_posts (
+  event_id INTEGER PRIMARY KEY,
+  posted_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (event_id) REFERENCES shipment_events(event_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS localities (
+  locality_id TEXT NOT NULL,
+  locality TEXT NOT NULL,
+  province TEXT NOT NULL,
+  zip TEXT NOT NULL,
+
+  PRIMARY KEY (locality_id, zip)
+);
+
+CREATE INDEX IF NOT EXISTS idx_localities_zip ON localities(zip);
+
+CREATE TABLE IF NOT EXISTS localities_sync (
+  sync_id INTEGER PRIMARY KEY CHECK (sync_id = 1),
+  synced_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sender_settings (
+  shop TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  phone TEXT NOT NULL,
+  email TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS package_cancellations (
+  shipping_id INTEGER NOT NULL,
+  shipping_number TEXT NOT NULL,
+  cancelled_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+  PRIMARY KEY (shipping_id, shipping_number),
+  FOREIGN KEY (shipping_id) REFERENCES shippings(shipping_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_quotes_created ON rate_quotes(created_at);
@@ -1,35 +1,48 @@
 package andreani
 
 import (
-	"io"
 	"fmt"
+	"context"
 	"time"
 	"bytes"
-	"errors"
 	"strings"
+	"strconv"
+	"sync"
 
 	"net/http"
 	"net/url"
 
 	"encoding/json"
+
+	"tomi/src/money"
 )
 
 type Api struct {
-	clientCode string 
-	token      string
-	baseUrl    string
-	client     *http.Client
+	credentials Credentials
+	baseUrl     string
+	client      *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	refresh   *tokenRefresh
 }
 
-func NewApi(clientCode string, token string, baseUrl string) *Api {
-	return &Api{
-		clientCode: clientCode,
-		token: token,
+func NewApi(credentials Credentials, baseUrl string) *Api {
+	api := &Api{
+		credentials: credentials,
 		baseUrl: baseUrl,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 	}
+
+	if credentials.Token != "" {
+		api.token = credentials.Token
+		api.expiresAt = tokenExpiry(credentials.Token, time.Now())
+	}
+
+	return api
 }
 
 type Address struct {
@@ -50,7 +63,7 @@ type Coordenada struct {
 type DatosAdicionales struct {
 	SeHaceAtencionAlCliente bool   `json:"seHaceAtencionAlCliente"`
 	ConBuzonInteligente     bool   `json:"conBuzonInteligente"`
-	tipo                    string `json:"tipo"`
+	Tipo                    string `json:"tipo"`
 }
 
 type Office struct {
@@ -80,6 +93,11 @@ type Fee struct {
 	Total              string `json:"total"`
 }
 
+// TotalMoney parses the decimal pesos of the total, Andreani only quotes in ARS.
+func (f *Fee) TotalMoney() (money.Money, error) {
+	return money.Parse(f.Total, money.DefaultCurrency)
+}
+
 type Rate struct {
 	PesoAforado  string `json:"pesoAforado"`
 	TarifaSinIva Fee    `json:"tarifaSinIva"`
@@ -91,6 +109,18 @@ type Postal struct {
 	Calle        string `json:"calle"`
 	Numero       string `json:"numero"`
 	Localidad    string `json:"localidad"`
+	Region       string `json:"region,omitempty"`
+}
+
+type Sucursal struct {
+	ID string `json:"id"`
+}
+
+// Origin is where Andreani takes the parcels from, the postal address or the
+// branch they are dropped off at.
+type Origin struct {
+	Postal   *Postal   `json:"postal,omitempty"`
+	Sucursal *Sucursal `json:"sucursal,omitempty"`
 }
 
 type Telefono struct {
@@ -100,6 +130,7 @@ type Telefono struct {
 
 type Persona struct {
 	NombreCompleto string     `json:"nombreCompleto"`
+	Email          string     `json:"eMail,omitempty"`
 	Telefonos      []Telefono `json:"telefonos"`
 }
 
@@ -171,8 +202,6 @@ func (api *Api) GetLocations(query LocationQuery) ([]Location, error) {
 	
 	baseUrl.RawQuery = q.Encode()
 
-	fmt.Println(baseUrl.String())
-	
 	req, err := http.NewRequest("GET", baseUrl.String(), nil)
 	if err != nil {
 		return nil, err
@@ -184,12 +213,8 @@ func (api *Api) GetLocations(query LocationQuery) ([]Location, error) {
   }
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("fail to get location")
-	}
-
 	locations := []Location{}
-	if err := json.NewDecoder(resp.Body).Decode(&locations); err != nil {
+	if err := decodeResponse(resp, &locations); err != nil {
 		return nil, err
 	}
 	
@@ -256,29 +281,44 @@ func (api *Api) GetOffices(query OfficeQuery) ([]Office, error) {
   }
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("fail to get offices")
-	}
-
 	offices := []Office{}
-	if err := json.NewDecoder(resp.Body).Decode(&offices); err != nil {
+	if err := decodeResponse(resp, &offices); err != nil {
 		return nil, err
 	}
 	
 	return offices, nil
 }
 
-func (api *Api) CalculateShippingRate(contract, zip, volume string) (*Rate, error) {
-	url := fmt.Sprintf(
-		"%s/v1/tarifas?cpDestino=%s&contrato=%s&cliente=%s&bultos[0][volumen]=%s",
-		api.baseUrl,
-		zip,
-		contract,
-		api.clientCode,
-		volume,
-	)
-
-	req, err := http.NewRequest("GET", url, nil)
+type RateQuery struct {
+	Contract     string
+	Zip          string
+	OriginBranch string
+	Bultos       []Bulto
+}
+
+func (api *Api) CalculateShippingRate(ctx context.Context, query RateQuery) (*Rate, error) {
+	baseUrl, err := url.Parse(fmt.Sprintf("%s/v1/tarifas", api.baseUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	q := baseUrl.Query()
+	q.Set("cpDestino", query.Zip)
+	q.Set("contrato", query.Contract)
+	q.Set("cliente", api.credentials.ClientCode)
+
+	for i, bulto := range query.Bultos {
+		q.Set(fmt.Sprintf("bultos[%d][volumen]", i), strconv.FormatFloat(bulto.VolumenCm, 'f', 2, 64))
+		q.Set(fmt.Sprintf("bultos[%d][kilos]", i), strconv.FormatFloat(bulto.Kilos, 'f', 3, 64))
+	}
+
+	if query.OriginBranch != "" {
+		q.Set("sucursalOrigen", query.OriginBranch)
+	}
+
+	baseUrl.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +330,7 @@ func (api *Api) CalculateShippingRate(contract, zip, volume string) (*Rate, erro
 	defer resp.Body.Close()
 
 	rate := &Rate{}
-	if err := json.NewDecoder(resp.Body).Decode(rate); err != nil {
+	if err := decodeResponse(resp, rate); err != nil {
 		return nil, err
 	}
 	
@@ -298,22 +338,20 @@ func (api *Api) CalculateShippingRate(contract, zip, volume string) (*Rate, erro
 }
 
 func (api *Api) CreateShipping(
+	ctx context.Context,
 	contrato string,
-	origen, destino Postal,
+	origen Origin,
+	destino Postal,
 	remitente, destinatario Persona,
 	bultos []Bulto) (*Order, error) {
 	
-	type Origen struct {
-		Postal Postal `json:"postal"`
-	}
-	
 	type Destino struct {
 		Postal Postal `json:"postal"`
 	}
 
 	type Payload struct {
 		Contrato 	   string  `json:"contrato"`
-		Origen       Origen  `json:"origen"`
+		Origen       Origin  `json:"origen"`
 		Destino      Destino `json:"destino"`
 		Remitente    Persona `json:"remitente"`
 		Destinatario []Persona `json:"destinatario"`
@@ -324,7 +362,7 @@ func (api *Api) CreateShipping(
 	
 	payload := Payload {
 		Contrato: contrato,
-		Origen:  Origen{Postal: origen},
+		Origen:  origen,
 		Destino: Destino{Postal: destino},
 		Remitente: remitente,
 		Destinatario: []Persona{destinatario},
@@ -336,32 +374,21 @@ func (api *Api) CreateShipping(
 		return nil, err
 	}
 	
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type",  "application/json")
-	req.Header.Set("x-authorization-token", api.token)
-	
-	resp, err := api.client.Do(req)
+	resp, err := api.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type",  "application/json")
+		return req, nil
+	})
   if err != nil {
 		return nil, err
   }
 	defer resp.Body.Close()
 
-	bytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	
-	fmt.Println(string(bytes))
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New("shopify create shipping failed")
-	}
-	
 	order := &Order{}
-	if err := json.NewDecoder(resp.Body).Decode(order); err != nil {
+	if err := decodeResponse(resp, order); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,187 @@
+package andreani
+
+import (
+	"time"
+	"errors"
+	"strings"
+	"context"
+
+	"net/http"
+
+	"encoding/json"
+	"encoding/base64"
+)
+
+// Andreani tokens last a day, renew a bit earlier when the token doesn't say.
+const defaultTokenLifetime = 23 * time.Hour
+
+// tokens are renewed this long before they expire so in flight requests
+// don't carry an expired token.
+const tokenExpiryMargin = time.Minute
+
+var ErrNoCredentials = errors.New("andreani username and password are required to login")
+
+type Credentials struct {
+	ClientCode string
+	Username   string
+	Password   string
+	// Token is used until it expires or is rejected, it can be empty.
+	Token string
+}
+
+// tokenExpiry reads the exp claim when the token is a JWT.
+func tokenExpiry(token string, now time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return now.Add(defaultTokenLifetime)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return now.Add(defaultTokenLifetime)
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return now.Add(defaultTokenLifetime)
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
+
+func (api *Api) login(ctx context.Context) (string, error) {
+	if api.credentials.Username == "" || api.credentials.Password == "" {
+		return "", ErrNoCredentials
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", api.baseUrl+"/login", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(api.credentials.Username, api.credentials.Password)
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := readResponse(resp)
+	if err != nil {
+		return "", err
+	}
+
+	token := resp.Header.Get("x-authorization-token")
+	if token == "" {
+		var payload struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return "", err
+		}
+		token = payload.Token
+	}
+
+	if token == "" {
+		return "", errors.New("andreani login returned no token")
+	}
+
+	return token, nil
+}
+
+// tokenRefresh is a login in progress, done is closed once token and err
+// are set.
+type tokenRefresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// Token returns the cached token, logging in again when it is missing or
+// about to expire. Concurrent callers share a single login and the lock is
+// not held during it, each caller waits for it at most until its ctx is done.
+func (api *Api) Token(ctx context.Context) (string, error) {
+	api.mu.Lock()
+	if api.token != "" && time.Now().Before(api.expiresAt.Add(-tokenExpiryMargin)) {
+		token := api.token
+		api.mu.Unlock()
+		return token, nil
+	}
+
+	refresh := api.refresh
+	if refresh == nil {
+		refresh = &tokenRefresh{done: make(chan struct{})}
+		api.refresh = refresh
+		go api.runRefresh(refresh)
+	}
+	api.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.token, refresh.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// runRefresh logs in outside of any caller context, so a caller giving up
+// doesn't fail the login for the others. The client timeout bounds it.
+func (api *Api) runRefresh(refresh *tokenRefresh) {
+	now := time.Now()
+	token, err := api.login(context.Background())
+
+	api.mu.Lock()
+	if err == nil {
+		api.token = token
+		api.expiresAt = tokenExpiry(token, now)
+	}
+	api.refresh = nil
+	api.mu.Unlock()
+
+	refresh.token = token
+	refresh.err = err
+	close(refresh.done)
+}
+
+// dropToken forgets a rejected token unless another request already
+// replaced it.
+func (api *Api) dropToken(token string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.token == token {
+		api.token = ""
+	}
+}
+
+// doAuthorized sends the request with the token and, when Andreani rejects
+// it, logs in again and retries once. newRequest is called per attempt so the
+// body can be sent again.
+func (api *Api) doAuthorized(ctx context.Context, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := api.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-authorization-token", token)
+
+		resp, err := api.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			api.dropToken(token)
+			continue
+		}
+
+		return resp, nil
+	}
+}
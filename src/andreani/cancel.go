@@ -0,0 +1,25 @@
+package andreani
+
+import (
+	"context"
+
+	"net/http"
+	"net/url"
+)
+
+// CancelShipping cancels the pre-envío of a numeroDeEnvio, Andreani only
+// accepts it before the package is admitted.
+func (api *Api) CancelShipping(ctx context.Context, numero string) error {
+	path := "/v2/ordenes-de-envio/" + url.PathEscape(numero)
+
+	resp, err := api.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "DELETE", api.baseUrl+path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = readResponse(resp)
+	return err
+}
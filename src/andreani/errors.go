@@ -0,0 +1,132 @@
+package andreani
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"strings"
+
+	"net/http"
+
+	"encoding/json"
+)
+
+// maxErrorBody limits how much of an unreadable error body ends in the
+// message.
+const maxErrorBody = 512
+
+// Error is a response Andreani answered with a non 2xx status. Fields holds
+// the messages of the request fields Andreani rejected.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  map[string]string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "andreani request failed with status %d", e.Status)
+	if e.Code != "" {
+		fmt.Fprintf(&b, " (%s)", e.Code)
+	}
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Fprintf(&b, "; %s: %s", field, e.Fields[field])
+	}
+
+	return b.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseError reads the error payloads Andreani uses, the problem details of
+// the v2 apis and the code/mensaje bodies of the older ones.
+func parseError(status int, body []byte) *Error {
+	apiErr := &Error{Status: status, Fields: map[string]string{}}
+
+	type validation struct {
+		Field   string `json:"field"`
+		Campo   string `json:"campo"`
+		Message string `json:"message"`
+		Mensaje string `json:"mensaje"`
+	}
+
+	var payload struct {
+		Type        string       `json:"type"`
+		Code        string       `json:"code"`
+		Codigo      string       `json:"codigo"`
+		Title       string       `json:"title"`
+		Detail      string       `json:"detail"`
+		Message     string       `json:"message"`
+		Mensaje     string       `json:"mensaje"`
+		Validations []validation `json:"validations"`
+		Errores     []validation `json:"errores"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		message := strings.TrimSpace(string(body))
+		if len(message) > maxErrorBody {
+			message = message[:maxErrorBody]
+		}
+		apiErr.Message = message
+		return apiErr
+	}
+
+	apiErr.Code = firstNonEmpty(payload.Code, payload.Codigo, payload.Type)
+	apiErr.Message = firstNonEmpty(payload.Detail, payload.Message, payload.Mensaje, payload.Title)
+
+	for _, v := range append(payload.Validations, payload.Errores...) {
+		field := firstNonEmpty(v.Field, v.Campo)
+		if field == "" {
+			continue
+		}
+		apiErr.Fields[field] = firstNonEmpty(v.Message, v.Mensaje)
+	}
+
+	return apiErr
+}
+
+// readResponse reads the whole body once and turns the non 2xx responses into
+// an *Error.
+func readResponse(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, parseError(resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+// decodeResponse reads the response and decodes the body into result.
+func decodeResponse(resp *http.Response, result interface{}) error {
+	body, err := readResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("andreani response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package andreani
+
+import (
+	"fmt"
+	"strings"
+	"context"
+
+	"net/http"
+	"net/url"
+)
+
+type Label struct {
+	ContentType string
+	Content     []byte
+}
+
+func (api *Api) getLabel(ctx context.Context, labelUrl string) (*Label, error) {
+	resp, err := api.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", labelUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/pdf")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := readResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/pdf"
+	}
+
+	return &Label{ContentType: contentType, Content: content}, nil
+}
+
+// GetPackageLabel downloads the label of a single bulto from the link
+// Andreani returned when the shipping was created.
+func (api *Api) GetPackageLabel(ctx context.Context, link string) (*Label, error) {
+	if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+		link = api.baseUrl + "/" + strings.TrimPrefix(link, "/")
+	}
+	return api.getLabel(ctx, link)
+}
+
+// GetGroupLabel downloads the labels of every bulto in the group as one file.
+func (api *Api) GetGroupLabel(ctx context.Context, agrupador string) (*Label, error) {
+	labelUrl := fmt.Sprintf("%s/v2/ordenes-de-envio/%s/etiquetas", api.baseUrl, url.PathEscape(agrupador))
+	return api.getLabel(ctx, labelUrl)
+}
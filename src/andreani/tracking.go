@@ -0,0 +1,59 @@
+package andreani
+
+import (
+	"context"
+
+	"net/http"
+	"net/url"
+)
+
+type ShipmentStatus struct {
+	NumeroDeTracking       string        `json:"numeroDeTracking"`
+	Estado                 string        `json:"estado"`
+	EstadoId               int           `json:"estadoId"`
+	FechaEstado            string        `json:"fechaEstado"`
+	SucursalDeDistribucion OrderSucursal `json:"sucursalDeDistribucion"`
+}
+
+type TrackingEvent struct {
+	Fecha      string `json:"Fecha"`
+	Estado     string `json:"Estado"`
+	EstadoId   int    `json:"EstadoId"`
+	Motivo     string `json:"Motivo"`
+	Sucursal   string `json:"Sucursal"`
+	SucursalId int    `json:"SucursalId"`
+	Ciclo      string `json:"Ciclo"`
+}
+
+func (api *Api) getAuthorizedJSON(ctx context.Context, path string, result interface{}) error {
+	resp, err := api.doAuthorized(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", api.baseUrl+path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, result)
+}
+
+// GetShipmentStatus returns the current state of a shipment by its
+// numeroDeEnvio.
+func (api *Api) GetShipmentStatus(ctx context.Context, numero string) (*ShipmentStatus, error) {
+	status := &ShipmentStatus{}
+	if err := api.getAuthorizedJSON(ctx, "/v2/envios/"+url.PathEscape(numero), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetTrackingEvents returns every state the shipment went through.
+func (api *Api) GetTrackingEvents(ctx context.Context, numero string) ([]TrackingEvent, error) {
+	var body struct {
+		Eventos []TrackingEvent `json:"eventos"`
+	}
+	if err := api.getAuthorizedJSON(ctx, "/v2/envios/"+url.PathEscape(numero)+"/trazas", &body); err != nil {
+		return nil, err
+	}
+	return body.Eventos, nil
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"strconv"
-	"fmt"
 	"log"
 	"os"
+	"errors"
+	"context"
 
 	"encoding/json"
 
@@ -12,8 +12,8 @@ import (
 	"net/http/httputil"
 	"net/url"
 
-	"tomi/src/andreani"
 	"tomi/src/database"
+	"tomi/src/packing"
 	"tomi/src/shopify"
 )
 
@@ -24,14 +24,21 @@ type Application struct {
 	shop string
 
 	shopApi *shopify.Api
-	andApi  *andreani.Api
+	andApis *AndreaniApis
 
 	events       chan Event
 	lastEventIds *EventIdSB
+	bulkJobs     *BulkJobs
+
+	rateStats RateStats
+	rateCache *RateCache
+	quotes    *QuoteRecorder
+
+	trackingRefreshes *RefreshLimiter
 }
 
 func NewAppication() (*Application, error) {
-	db, err := database.NewDatabase("./database/schema.sql")
+	db, err := database.NewDatabase("./database/schema.sql", os.Getenv("SETTINGS_SECRET_KEY"))
 	if err != nil {
 		return nil, err
 	}
@@ -47,12 +54,6 @@ func NewAppication() (*Application, error) {
 		os.Getenv("SHOPIFY_CLIENT_SECRET"),
 	)
 
-	andApi := andreani.NewApi(
-		os.Getenv("ANDREANI_CLIENT_CODE"),
-		os.Getenv("ANDREANI_ACCESS_TOKEN"),
-		os.Getenv("ANDREANI_BASE_URL"),
-	)
-
 	events := make(chan Event, 512)
 
 	app := &Application{
@@ -60,12 +61,23 @@ func NewAppication() (*Application, error) {
 		proxy:        proxy,
 		shop:         os.Getenv("SHOPIFY_SHOP_NAME"),
 		shopApi:      shopApi,
-		andApi:       andApi,
+		andApis:      NewAndreaniApis(os.Getenv("ANDREANI_BASE_URL")),
 		events:       events,
 		lastEventIds: NewEventIdSB(),
+		bulkJobs:     NewBulkJobs(),
+		rateCache:    newRateCacheFromEnv(db),
+		quotes:       newQuoteRecorderFromEnv(db),
+
+		trackingRefreshes: NewRefreshLimiter(trackingRefreshInterval),
 	}
 
 	go app.ProcessEvents()
+	go app.RunReconciler(reconcileInterval())
+	go app.RunTrackingPoller(trackingInterval())
+	go app.RunLocalitiesSync(localitiesInterval())
+	go app.rateCache.RunPurge()
+	go app.quotes.Run()
+	go app.quotes.RunPurge()
 
 	return app, nil
 }
@@ -134,6 +146,8 @@ func (app *Application) AuthCallbackHandler(w http.ResponseWriter, r *http.Reque
 	http.Redirect(w, r, embeddedUrl, http.StatusFound)
 }
 
+// GetOrdersHandler lists the unfulfilled orders flagging the ones whose
+// address Andreani won't accept, ?flagged=true lists only those.
 func (app *Application) GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	orders, err := app.db.GetUnfulfilledOrders(app.shop)
 	if err != nil {
@@ -142,9 +156,30 @@ func (app *Application) GetOrdersHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	type listedOrder struct {
+		database.Order
+		AddressIssues ValidationErrors `json:"address_issues,omitempty"`
+	}
+
+	flaggedOnly := r.URL.Query().Get("flagged") == "true"
+
+	listed := []listedOrder{}
+	for _, order := range orders {
+		issues, err := app.addressIssues(order.ShippingAddress)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if flaggedOnly && len(issues) == 0 {
+			continue
+		}
+		listed = append(listed, listedOrder{Order: order, AddressIssues: issues})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(orders); err != nil {
+	if err := json.NewEncoder(w).Encode(listed); err != nil {
 		log.Println("json encode error:", err.Error())
 	}
 }
@@ -300,10 +335,12 @@ func (app *Application) CarrierServiceCallbackHandler(w http.ResponseWriter, r *
 	}
 
 	type Item struct {
-		Quantity  int   `json:"quantity"`
-		Grams     int64 `json:"grams"`
-		ProductID int64 `json:"product_id"`
-		VariantID int64 `json:"variant_id"`
+		Quantity  int    `json:"quantity"`
+		Grams     int64  `json:"grams"`
+		Price     int64  `json:"price"`
+		Sku       string `json:"sku"`
+		ProductID int64  `json:"product_id"`
+		VariantID int64  `json:"variant_id"`
 	}
 
 	var payload struct {
@@ -319,61 +356,108 @@ func (app *Application) CarrierServiceCallbackHandler(w http.ResponseWriter, r *
 		return
 	}
 
+	// The shop deadline covers the whole callback, packing may ask shopify
+	// for dimensions before Andreani is asked for rates.
+	fallback := app.fallbackSettings(app.shop)
+	ctx, cancel := context.WithTimeout(r.Context(), fallback.Timeout())
+	defer cancel()
+
+	cart := RuleCart{
+		Province: payload.Rate.Destination.Province,
+		Zip:      payload.Rate.Destination.PostalCode,
+	}
+
 	items := make([]PackageItem, 0, len(payload.Rate.Items))
 	for _, it := range payload.Rate.Items {
+		cart.Subtotal += it.Price * int64(it.Quantity)
+		cart.Skus = append(cart.Skus, it.Sku)
+
 		item := PackageItem{
-			ProductID: fmt.Sprintf("gid://shopify/Product/%d", it.ProductID),
+			ProductID: it.ProductID,
+			VariantID: it.VariantID,
+			Grams:     it.Grams,
 			Quantity:  it.Quantity,
 		}
 		items = append(items, item)
 	}
 
-	volumen, err := calculatePackageVolumen(app.shopApi, token.Access, app.shop, items)
+	var result struct {
+		Rates []CarrierRate `json:"rates"`
+	}
+	result.Rates = []CarrierRate{}
+
+	bultos, err := app.packItems(ctx, token.Access, app.shop, items)
+	var missing *MissingDimensionsError
+	var oversized *packing.OversizedError
+	if errors.As(err, &missing) || errors.As(err, &oversized) || (err != nil && ctx.Err() != nil) {
+		// A parcel without the volume of these items would be under quoted,
+		// oversized items can't travel as andreani parcels and a late answer
+		// is dropped by shopify, the checkout gets no andreani rates instead.
+		log.Println(err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Println("json encode error:", err.Error())
+		}
+		return
+	}
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// TODO: These contracts needs to be loaded from database
-	// TODO: Sucursal de origen si los packetes son depositados para ser enviados
-	contratoEntrega := "400017493"
-	zip := onlyDigits(payload.Rate.Destination.PostalCode)
-	volumenStr := strconv.FormatFloat(volumen, 'f', 2, 64)
-	
-	rate, err := app.andApi.CalculateShippingRate(contratoEntrega, zip, volumenStr)
+	andApi, settings, err := app.andreaniApi(app.shop)
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	
-	totalPrice, err := strconv.ParseFloat(rate.TarifaConIva.Total, 64)
+
+	origin, err := app.rateOrigin(app.shop, payload.Rate.Origin.PostalCode)
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	type Rate struct {
-		ServiceName string `json:"service_name"`
-		ServiceCode string `json:"service_code"`
-		TotalPrice  string `json:"total_price"`
-		Description string `json:"description"`
-		Currency    string `json:"currency"`
+	quote := QuoteRequest{
+		Zip:          postalCode(payload.Rate.Destination.PostalCode),
+		Province:     payload.Rate.Destination.Province,
+		OriginBranch: settings.OriginBranch,
+		Bultos:       bultos,
 	}
-		
-	var result struct {
-		Rates []Rate `json:"rates"`
+
+	originProvince := payload.Rate.Origin.Province
+	if origin != nil {
+		if branch := originValue(origin.Branch); branch != "" {
+			quote.OriginBranch = branch
+		}
+		if province := originValue(origin.Province); province != "" {
+			originProvince = province
+		}
+	}
+
+	rates := app.quoteServices(ctx, fallback, andApi, settings, quote)
+	if len(rates) == 0 {
+		log.Println("no andreani service could be quoted")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rates, err = app.applyShopRules(app.shop, &cart, rates)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.estimateDelivery(app.shop, originProvince, quote, rates); err != nil {
+		// rates are still useful without an estimated delivery date.
+		log.Println(err.Error())
 	}
 
-	result.Rates = append(result.Rates, Rate{
-		ServiceName: "Andreani a domicilio",
-		ServiceCode: contratoEntrega,
-		TotalPrice: fmt.Sprintf("%d", int64(totalPrice * 100)),
-		Description: "envio directo a tu domicilio",
-		Currency: "ARS",
-	})
+	result.Rates = rates
 	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
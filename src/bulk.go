@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"net/http"
+
+	"encoding/json"
+
+	"tomi/src/shopify"
+)
+
+const bulkPollInterval = 10 * time.Second
+
+type bulkJob struct {
+	shop string
+	name string
+	run  func(op *shopify.BulkOperation) error
+}
+
+type BulkJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*bulkJob
+}
+
+func NewBulkJobs() *BulkJobs {
+	return &BulkJobs{
+		jobs: map[string]*bulkJob{},
+	}
+}
+
+func (b *BulkJobs) Add(id string, job *bulkJob) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.jobs[id] = job
+}
+
+// Take removes the job so only one of the webhook and the poller runs it.
+func (b *BulkJobs) Take(id string) (*bulkJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if ok {
+		delete(b.jobs, id)
+	}
+	return job, ok
+}
+
+func (b *BulkJobs) Pending(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.jobs[id]
+	return ok
+}
+
+func (app *Application) startBulkOperation(shop, name, query string, run func(op *shopify.BulkOperation) error) (*shopify.BulkOperation, error) {
+	token, err := app.db.GetAccessToken(shop)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := app.shopApi.BulkOperationRunQuery(shop, token.Access, query)
+	if err != nil {
+		return nil, err
+	}
+
+	app.bulkJobs.Add(op.ID, &bulkJob{shop: shop, name: name, run: run})
+	go app.pollBulkOperation(shop, op.ID)
+
+	log.Printf("bulk operation %s started: %s\n", name, op.ID)
+	return op, nil
+}
+
+// pollBulkOperation is the fallback for when the bulk_operations/finish
+// webhook never reaches us.
+func (app *Application) pollBulkOperation(shop, id string) {
+	for app.bulkJobs.Pending(id) {
+		time.Sleep(bulkPollInterval)
+
+		token, err := app.db.GetAccessToken(shop)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		op, err := app.shopApi.GetBulkOperation(shop, token.Access, id)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+
+		if op.Finished() {
+			app.finishBulkOperation(id)
+			return
+		}
+	}
+}
+
+func (app *Application) finishBulkOperation(id string) {
+	job, ok := app.bulkJobs.Take(id)
+	if !ok {
+		return
+	}
+
+	token, err := app.db.GetAccessToken(job.shop)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	op, err := app.shopApi.GetBulkOperation(job.shop, token.Access, id)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	if op.Status != "COMPLETED" {
+		log.Printf("bulk operation %s finished with status %s\n", job.name, op.Status)
+		return
+	}
+
+	// Operations without results don't have a download url.
+	if op.Url == nil {
+		log.Printf("bulk operation %s finished without results\n", job.name)
+		return
+	}
+
+	if err := job.run(op); err != nil {
+		log.Printf("bulk operation %s failed: %s\n", job.name, err.Error())
+		return
+	}
+
+	log.Printf("bulk operation %s finished: %s objects\n", job.name, op.ObjectCount)
+}
+
+func (app *Application) BackfillOrders(since time.Time) (*shopify.BulkOperation, error) {
+	query := fmt.Sprintf(shopify.OrdersBulkQuery, since.UTC().Format(time.RFC3339))
+	return app.startBulkOperation(app.shop, "orders backfill", query, func(op *shopify.BulkOperation) error {
+		corrections := 0
+		err := shopify.ReadBulkOrders(*op.Url, func(order *shopify.BulkOrder) error {
+			n, err := app.reconcileOrder(order, app.shop)
+			if err != nil {
+				log.Printf("backfill order %s: %s\n", order.ID, err.Error())
+			}
+			corrections += n
+			return nil
+		})
+		log.Printf("orders backfill: %d corrections\n", corrections)
+		return err
+	})
+}
+
+func (app *Application) BackfillOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Since *time.Time `json:"since"`
+	}
+
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	since := time.Now().AddDate(-1, 0, 0)
+	if payload.Since != nil {
+		since = *payload.Since
+	}
+
+	op, err := app.BackfillOrders(since)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(op); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
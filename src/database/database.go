@@ -3,15 +3,26 @@ package database
 import (
 	"os"
 	"time"
+	"crypto/cipher"
 	"database/sql"
+	"encoding/json"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Database struct {
 	handle *sql.DB
+	secret cipher.AEAD
 }
 
-func NewDatabase(schemaPath string) (*Database, error) {
+// NewDatabase opens the database, secretKey (SETTINGS_SECRET_KEY) encrypts
+// the stored carrier passwords and access tokens. Without it they are stored
+// in plaintext and sealed on the first start with a key.
+func NewDatabase(schemaPath string, secretKey string) (*Database, error) {
+	secret, err := newSecretCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+
 	handle, err := sql.Open("sqlite3", "file:./database/sqlite.db?_foreign_keys=on")
 	if err != nil {
 		return nil, err
@@ -28,7 +39,11 @@ func NewDatabase(schemaPath string) (*Database, error) {
 		handle.Close()
 		return nil, err
 	}
-	db := &Database{handle: handle}
+	db := &Database{handle: handle, secret: secret}
+	if err := db.sealPlaintextCredentials(); err != nil {
+		handle.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
@@ -97,7 +112,7 @@ type OrderItem struct {
 type Order struct {
 	OrderID           int64  			`json:"order_id"`
 	OrderApiID        string 			`json:"order_api_id"`
-	Shop              string 			`json::"shop"`
+	Shop              string 			`json:"shop"`
 	Currency          string 			`json:"currency"`
 	SubtotalPrice     int64  			`json:"subtotal_price"`
 	ShippingPrice     int64  			`json:"shipping_price"`
@@ -110,6 +125,7 @@ type Order struct {
 	Paid              bool        `json:"paid"`
 	Fulfilled         bool        `json:"fulfilled"`
 	Deleted           bool        `json:"deleted"`
+	DeliveredAt       *time.Time  `json:"delivered_at"`
 	UpdatedAt         time.Time 	`json:"updated_at"`
 	CreatedAt         time.Time 	`json:"created_at"`
 	
@@ -401,6 +417,27 @@ func (db *Database) GetLastUpdatedFromOrder(orderID int64) (time.Time, error) {
 	return lastUpdatedAt, nil
 }
 
+type OrderState struct {
+	Paid      bool
+	Fulfilled bool
+	Cancelled bool
+	UpdatedAt time.Time
+}
+
+func (db *Database) GetOrderState(orderID int64) (*OrderState, error) {
+	query := `SELECT paid, fulfilled, cancelled, updated_at FROM orders WHERE order_id = ?;`
+	state := &OrderState{}
+	if err := db.handle.QueryRow(query, orderID).Scan(
+		&state.Paid,
+		&state.Fulfilled,
+		&state.Cancelled,
+		&state.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
 func (db *Database) DeleteOrder(orderID int64) error {
 	var err error
 	var query string
@@ -458,6 +495,34 @@ func (db *Database) CancelOrder(order *Order) error {
 	return nil
 }
 
+// UnfulfillOrder clears the fulfilled flag, shopify reopens an order when its
+// fulfillments are cancelled.
+func (db *Database) UnfulfillOrder(order *Order) error {
+	query := `
+		UPDATE orders SET
+			fulfilled = FALSE
+		WHERE order_id = ?;
+	`
+	_, err := db.handle.Exec(query, order.OrderID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) UncancelOrder(order *Order) error {
+	query := `
+		UPDATE orders SET
+			cancelled = FALSE
+		WHERE order_id = ?;
+	`
+	_, err := db.handle.Exec(query, order.OrderID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (db *Database) OrderWasDeleted(orderID int64) bool {
 	query := `
 		SELECT 1
@@ -522,12 +587,13 @@ func (db *Database) GetUnfulfilledOrders(shop string) ([]Order, error) {
 			o.subtotal_price, o.shipping_price, o.discount, o.total_price,
 			o.carrier_name, o.carrier_code, o.carrier_price,
 			o.cancelled, o.paid, o.fulfilled,
-			o.updated_at, o.created_at,
+			o.updated_at, o.created_at, d.delivered_at,
 			a.address_id, a.email, a.phone, a.name, a.last_name, 
 			a.address1, a.address2,
 			a."number", a.city, a.zip, a.province, a.country 
 		FROM orders AS o 
 		JOIN addresses AS a ON o.order_id = a.order_id
+		LEFT JOIN order_deliveries AS d ON o.order_id = d.order_id
 		WHERE 
 			shop = ?
 			AND fulfilled = FALSE
@@ -562,6 +628,7 @@ func (db *Database) GetUnfulfilledOrders(shop string) ([]Order, error) {
 			&order.Fulfilled,
 			&order.UpdatedAt,
 			&order.CreatedAt,
+			&order.DeliveredAt,
 			&order.ShippingAddress.AddressID,
 			&order.ShippingAddress.Email,
 			&order.ShippingAddress.Phone,
@@ -589,6 +656,78 @@ func (db *Database) GetUnfulfilledOrders(shop string) ([]Order, error) {
 	return orders, nil
 }
 
+// GetOrder returns a shop order with its items, ShippingAddress is nil when
+// the order has no address stored.
+func (db *Database) GetOrder(shop string, orderID int64) (*Order, error) {
+	query := `
+		SELECT 
+			o.order_id, o.order_api_id, o.shop, o.currency,
+			o.subtotal_price, o.shipping_price, o.discount, o.total_price,
+			o.carrier_name, o.carrier_code, o.carrier_price,
+			o.cancelled, o.paid, o.fulfilled,
+			o.updated_at, o.created_at, d.delivered_at,
+			a.address_id, a.email, a.phone, a.name, a.last_name, 
+			a.address1, a.address2,
+			a."number", a.city, a.zip, a.province, a.country 
+		FROM orders AS o 
+		LEFT JOIN addresses AS a ON o.order_id = a.order_id
+		LEFT JOIN order_deliveries AS d ON o.order_id = d.order_id
+		WHERE o.shop = ? AND o.order_id = ?;
+	`
+
+	order := &Order{}
+	address := &Address{}
+	var addressID *int64
+
+	if err := db.handle.QueryRow(query, shop, orderID).Scan(
+		&order.OrderID,
+		&order.OrderApiID,
+		&order.Shop,
+		&order.Currency,
+		&order.SubtotalPrice,
+		&order.ShippingPrice,
+		&order.Discount,
+		&order.TotalPrice,
+		&order.CarrierName,
+		&order.CarrierCode,
+		&order.CarrierPrice,
+		&order.Cancelled,
+		&order.Paid,
+		&order.Fulfilled,
+		&order.UpdatedAt,
+		&order.CreatedAt,
+		&order.DeliveredAt,
+		&addressID,
+		&address.Email,
+		&address.Phone,
+		&address.Name,
+		&address.LastName,
+		&address.Address1,
+		&address.Address2,
+		&address.Number,
+		&address.City,
+		&address.Zip,
+		&address.Province,
+		&address.Country,
+	); err != nil {
+		return nil, err
+	}
+
+	if addressID != nil {
+		address.AddressID = *addressID
+		address.OrderID = &order.OrderID
+		order.ShippingAddress = address
+	}
+
+	items, err := db.GetOrderItems(order.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Items = items
+
+	return order, nil
+}
+
 func InsertShippingTx(tx *sql.Tx, shipping *Shipping) error {
 	query := `
 		INSERT INTO shippings (
@@ -602,7 +741,6 @@ func InsertShippingTx(tx *sql.Tx, shipping *Shipping) error {
 
 	res, err := tx.Exec(
 		query,
-		shipping.ShippingID,
 		shipping.OrderID,
 		shipping.State,
 		shipping.Type,
@@ -649,8 +787,8 @@ func InsertPackageTx(tx *sql.Tx, pkg *Package, shippingID int64) error {
 	return nil
 }
 
-func InsertShipping(db *sql.DB, shipping *Shipping) error {
-	tx, err := db.Begin()
+func (db *Database) InsertShipping(shipping *Shipping) error {
+	tx, err := db.handle.Begin()
 	if err != nil {
 		return err
 	}
@@ -661,6 +799,11 @@ func InsertShipping(db *sql.DB, shipping *Shipping) error {
 		return err
 	}
 
+	query := `INSERT INTO shipping_dates (shipping_id, created_at) VALUES (?, ?);`
+	if _, err := tx.Exec(query, shipping.ShippingID, time.Now().UTC()); err != nil {
+		return err
+	}
+
 	for i := range shipping.Packages {
 		if err := InsertPackageTx(tx, &shipping.Packages[i], shipping.ShippingID); err != nil {
 			return err
@@ -669,3 +812,1878 @@ func InsertShipping(db *sql.DB, shipping *Shipping) error {
 
 	return tx.Commit()
 }
+
+// GetOrderShippings returns the shippings of a shop order with their packages.
+func (db *Database) GetOrderShippings(shop string, orderID int64) ([]Shipping, error) {
+	query := `
+		SELECT s.shipping_id, s.order_id, s.state, s.type, s.package_group, s.package_group_labels
+		FROM shippings s
+		JOIN orders o ON o.order_id = s.order_id
+		WHERE o.shop = ? AND s.order_id = ?
+		ORDER BY s.shipping_id;
+	`
+
+	rows, err := db.handle.Query(query, shop, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shippings := []Shipping{}
+
+	for rows.Next() {
+		shipping := Shipping{}
+		if err := rows.Scan(
+			&shipping.ShippingID,
+			&shipping.OrderID,
+			&shipping.State,
+			&shipping.Type,
+			&shipping.PackageGroup,
+			&shipping.PackageGroupLabels,
+		); err != nil {
+			return nil, err
+		}
+		shippings = append(shippings, shipping)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range shippings {
+		packages, err := db.GetShippingPackages(shippings[i].ShippingID)
+		if err != nil {
+			return nil, err
+		}
+		shippings[i].Packages = packages
+	}
+
+	return shippings, nil
+}
+
+func (db *Database) GetShippingPackages(shippingID int64) ([]Package, error) {
+	query := `
+		SELECT "number", shipping_number, label
+		FROM packages
+		WHERE shipping_id = ?
+		ORDER BY rowid;
+	`
+
+	rows, err := db.handle.Query(query, shippingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	packages := []Package{}
+
+	for rows.Next() {
+		pkg := Package{}
+		if err := rows.Scan(&pkg.Number, &pkg.ShippingNumber, &pkg.Label); err != nil {
+			return nil, err
+		}
+		packages = append(packages, pkg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+func (db *Database) GetShipping(shop string, shippingID int64) (*Shipping, error) {
+	query := `
+		SELECT s.shipping_id, s.order_id, s.state, s.type, s.package_group, s.package_group_labels
+		FROM shippings s
+		JOIN orders o ON o.order_id = s.order_id
+		WHERE o.shop = ? AND s.shipping_id = ?;
+	`
+
+	shipping := &Shipping{}
+	if err := db.handle.QueryRow(query, shop, shippingID).Scan(
+		&shipping.ShippingID,
+		&shipping.OrderID,
+		&shipping.State,
+		&shipping.Type,
+		&shipping.PackageGroup,
+		&shipping.PackageGroupLabels,
+	); err != nil {
+		return nil, err
+	}
+
+	packages, err := db.GetShippingPackages(shipping.ShippingID)
+	if err != nil {
+		return nil, err
+	}
+	shipping.Packages = packages
+
+	return shipping, nil
+}
+
+// GetShippingsCreatedSince returns the ids of the shop shippings created
+// after since, oldest first.
+func (db *Database) GetShippingsCreatedSince(shop string, since time.Time) ([]int64, error) {
+	query := `
+		SELECT s.shipping_id
+		FROM shippings s
+		JOIN orders o ON o.order_id = s.order_id
+		JOIN shipping_dates d ON d.shipping_id = s.shipping_id
+		WHERE o.shop = ? AND d.created_at >= ?
+		ORDER BY d.created_at, s.shipping_id;
+	`
+
+	rows, err := db.handle.Query(query, shop, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (db *Database) UpdateShippingState(shippingID int64, state string) error {
+	query := `UPDATE shippings SET state = ? WHERE shipping_id = ?;`
+	_, err := db.handle.Exec(query, state, shippingID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ShipmentEvent is a state a package went through in Andreani.
+type ShipmentEvent struct {
+	EventID        int64     `json:"event_id"`
+	ShippingID     int64     `json:"shipping_id"`
+	ShippingNumber string    `json:"shipping_number"`
+	State          string    `json:"state"`
+	StateID        int       `json:"state_id"`
+	Reason         *string   `json:"reason"`
+	Branch         *string   `json:"branch"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// InsertShipmentEvent stores the event and reports false when it was
+// already stored.
+func (db *Database) InsertShipmentEvent(event *ShipmentEvent) (bool, error) {
+	query := `
+		INSERT INTO shipment_events (
+			shipping_id, shipping_number, state, state_id, reason, branch, occurred_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (shipping_id, shipping_number, state_id, occurred_at) DO NOTHING;
+	`
+	res, err := db.handle.Exec(
+		query,
+		event.ShippingID,
+		event.ShippingNumber,
+		event.State,
+		event.StateID,
+		event.Reason,
+		event.Branch,
+		event.OccurredAt.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	event.EventID = id
+
+	return true, nil
+}
+
+func (db *Database) GetShipmentEvents(shippingID int64) ([]ShipmentEvent, error) {
+	query := `
+		SELECT event_id, shipping_id, shipping_number, state, state_id, reason, branch, occurred_at
+		FROM shipment_events
+		WHERE shipping_id = ?
+		ORDER BY occurred_at, event_id;
+	`
+
+	rows, err := db.handle.Query(query, shippingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []ShipmentEvent{}
+
+	for rows.Next() {
+		event := ShipmentEvent{}
+		if err := rows.Scan(
+			&event.EventID,
+			&event.ShippingID,
+			&event.ShippingNumber,
+			&event.State,
+			&event.StateID,
+			&event.Reason,
+			&event.Branch,
+			&event.OccurredAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetUnpostedShipmentEvents returns the events of the shipping that were not
+// sent to shopify yet, oldest first.
+func (db *Database) GetUnpostedShipmentEvents(shippingID int64) ([]ShipmentEvent, error) {
+	query := `
+		SELECT e.event_id, e.shipping_id, e.shipping_number, e.state, e.state_id, e.reason, e.branch, e.occurred_at
+		FROM shipment_events e
+		LEFT JOIN shipment_event_posts p ON p.event_id = e.event_id
+		WHERE e.shipping_id = ? AND p.event_id IS NULL
+		ORDER BY e.occurred_at, e.event_id;
+	`
+
+	rows, err := db.handle.Query(query, shippingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []ShipmentEvent{}
+
+	for rows.Next() {
+		event := ShipmentEvent{}
+		if err := rows.Scan(
+			&event.EventID,
+			&event.ShippingID,
+			&event.ShippingNumber,
+			&event.State,
+			&event.StateID,
+			&event.Reason,
+			&event.Branch,
+			&event.OccurredAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (db *Database) MarkShipmentEventPosted(eventID int64) error {
+	query := `
+		INSERT INTO shipment_event_posts (event_id) VALUES (?)
+		ON CONFLICT (event_id) DO NOTHING;
+	`
+	_, err := db.handle.Exec(query, eventID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetUndeliveredShippings returns the ids of the shop shippings whose order
+// is neither cancelled nor delivered yet, oldest first.
+func (db *Database) GetUndeliveredShippings(shop string) ([]int64, error) {
+	query := `
+		SELECT s.shipping_id
+		FROM shippings s
+		JOIN orders o ON o.order_id = s.order_id
+		LEFT JOIN order_deliveries d ON d.order_id = o.order_id
+		WHERE o.shop = ? AND o.cancelled = FALSE AND d.order_id IS NULL
+		ORDER BY s.shipping_id;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (db *Database) SetShippingFulfillment(shippingID int64, fulfillmentID string) error {
+	query := `
+		INSERT INTO shipping_fulfillments (shipping_id, fulfillment_id)
+		VALUES (?, ?)
+		ON CONFLICT (shipping_id) DO UPDATE SET
+			fulfillment_id = excluded.fulfillment_id;
+	`
+	_, err := db.handle.Exec(query, shippingID, fulfillmentID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetShippingFulfillment returns the id of the shopify fulfillment created
+// for the shipping.
+func (db *Database) GetShippingFulfillment(shippingID int64) (string, error) {
+	query := `SELECT fulfillment_id FROM shipping_fulfillments WHERE shipping_id = ?;`
+	var fulfillmentID string
+	if err := db.handle.QueryRow(query, shippingID).Scan(&fulfillmentID); err != nil {
+		return "", err
+	}
+	return fulfillmentID, nil
+}
+
+// CancelShippingNumber records that Andreani cancelled one numeroDeEnvio of
+// the shipping.
+func (db *Database) CancelShippingNumber(shippingID int64, number string) error {
+	query := `
+		INSERT INTO package_cancellations (shipping_id, shipping_number) VALUES (?, ?)
+		ON CONFLICT (shipping_id, shipping_number) DO NOTHING;
+	`
+	_, err := db.handle.Exec(query, shippingID, number)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetCancelledShippingNumbers(shippingID int64) (map[string]bool, error) {
+	query := `SELECT shipping_number FROM package_cancellations WHERE shipping_id = ?;`
+
+	rows, err := db.handle.Query(query, shippingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	numbers := map[string]bool{}
+
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers[number] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return numbers, nil
+}
+
+// DeleteShippingFulfillment forgets the shopify fulfillment of the shipping
+// once it was cancelled.
+func (db *Database) DeleteShippingFulfillment(shippingID int64) error {
+	query := `DELETE FROM shipping_fulfillments WHERE shipping_id = ?;`
+	_, err := db.handle.Exec(query, shippingID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeliverOrder marks the order as delivered, keeping the first delivery
+// date when it is marked again.
+func (db *Database) DeliverOrder(orderID int64, deliveredAt time.Time) error {
+	query := `
+		INSERT INTO order_deliveries (order_id, delivered_at)
+		VALUES (?, ?)
+		ON CONFLICT (order_id) DO NOTHING;
+	`
+	_, err := db.handle.Exec(query, orderID, deliveredAt.UTC())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Label is a printable label, PackageNumber is empty for the label of the
+// whole package group.
+type Label struct {
+	LabelID       int64     `json:"label_id"`
+	ShippingID    int64     `json:"shipping_id"`
+	PackageNumber string    `json:"package_number"`
+	ContentType   string    `json:"content_type"`
+	Content       []byte    `json:"-"`
+	Sha256        string    `json:"sha256"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (db *Database) UpsertLabel(label *Label) error {
+	query := `
+		INSERT INTO labels (shipping_id, package_number, content_type, content, sha256)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (shipping_id, package_number) DO UPDATE SET
+			content_type = excluded.content_type,
+			content = excluded.content,
+			sha256 = excluded.sha256,
+			created_at = CURRENT_TIMESTAMP
+		RETURNING label_id, created_at;
+	`
+	return db.handle.QueryRow(
+		query,
+		label.ShippingID,
+		label.PackageNumber,
+		label.ContentType,
+		label.Content,
+		label.Sha256,
+	).Scan(&label.LabelID, &label.CreatedAt)
+}
+
+func (db *Database) GetLabel(shippingID int64, packageNumber string) (*Label, error) {
+	query := `
+		SELECT label_id, shipping_id, package_number, content_type, content, sha256, created_at
+		FROM labels
+		WHERE shipping_id = ? AND package_number = ?;
+	`
+	label := &Label{}
+	if err := db.handle.QueryRow(query, shippingID, packageNumber).Scan(
+		&label.LabelID,
+		&label.ShippingID,
+		&label.PackageNumber,
+		&label.ContentType,
+		&label.Content,
+		&label.Sha256,
+		&label.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return label, nil
+}
+
+type ProductDimensions struct {
+	Shop      string    `json:"shop"`
+	ProductID int64     `json:"product_id"`
+	Width     float64   `json:"width"`
+	Height    float64   `json:"height"`
+	Length    float64   `json:"length"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (db *Database) UpsertProductDimensions(dim *ProductDimensions) error {
+	query := `
+		INSERT INTO product_dimensions (
+			shop, product_id, width, height, length, updated_at
+		) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (shop, product_id) DO UPDATE SET
+			width = excluded.width,
+			height = excluded.height,
+			length = excluded.length,
+			updated_at = excluded.updated_at;
+	`
+	_, err := db.handle.Exec(
+		query,
+		dim.Shop,
+		dim.ProductID,
+		dim.Width,
+		dim.Height,
+		dim.Length,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetProductDimensions(shop string, productID int64) (*ProductDimensions, error) {
+	query := `
+		SELECT shop, product_id, width, height, length, updated_at
+		FROM product_dimensions
+		WHERE shop = ? AND product_id = ?;
+	`
+	dim := &ProductDimensions{}
+	if err := db.handle.QueryRow(query, shop, productID).Scan(
+		&dim.Shop,
+		&dim.ProductID,
+		&dim.Width,
+		&dim.Height,
+		&dim.Length,
+		&dim.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return dim, nil
+}
+
+func (db *Database) DeleteProductDimensions(shop string, productID int64) error {
+	query := `DELETE FROM product_dimensions WHERE shop = ? AND product_id = ?;`
+	_, err := db.handle.Exec(query, shop, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// VariantDimensions only holds the values set on the variant itself, nil
+// fields are resolved from the product or the shop defaults.
+type VariantDimensions struct {
+	Shop      string    `json:"shop"`
+	VariantID int64     `json:"variant_id"`
+	ProductID int64     `json:"product_id"`
+	Width     *float64  `json:"width"`
+	Height    *float64  `json:"height"`
+	Length    *float64  `json:"length"`
+	Grams     *int64    `json:"grams"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (db *Database) UpsertVariantDimensions(dim *VariantDimensions) error {
+	query := `
+		INSERT INTO variant_dimensions (
+			shop, variant_id, product_id, width, height, length, grams, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (shop, variant_id) DO UPDATE SET
+			product_id = excluded.product_id,
+			width = excluded.width,
+			height = excluded.height,
+			length = excluded.length,
+			grams = excluded.grams,
+			updated_at = excluded.updated_at;
+	`
+	_, err := db.handle.Exec(
+		query,
+		dim.Shop,
+		dim.VariantID,
+		dim.ProductID,
+		dim.Width,
+		dim.Height,
+		dim.Length,
+		dim.Grams,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetVariantDimensions(shop string, variantID int64) (*VariantDimensions, error) {
+	query := `
+		SELECT shop, variant_id, product_id, width, height, length, grams, updated_at
+		FROM variant_dimensions
+		WHERE shop = ? AND variant_id = ?;
+	`
+	dim := &VariantDimensions{}
+	if err := db.handle.QueryRow(query, shop, variantID).Scan(
+		&dim.Shop,
+		&dim.VariantID,
+		&dim.ProductID,
+		&dim.Width,
+		&dim.Height,
+		&dim.Length,
+		&dim.Grams,
+		&dim.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return dim, nil
+}
+
+func (db *Database) DeleteProductVariantDimensions(shop string, productID int64) error {
+	query := `DELETE FROM variant_dimensions WHERE shop = ? AND product_id = ?;`
+	_, err := db.handle.Exec(query, shop, productID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type DimensionDefaults struct {
+	Shop   string  `json:"shop"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Length float64 `json:"length"`
+	Grams  int64   `json:"grams"`
+}
+
+func (db *Database) UpsertDimensionDefaults(defaults *DimensionDefaults) error {
+	query := `
+		INSERT INTO dimension_defaults (
+			shop, width, height, length, grams
+		) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (shop) DO UPDATE SET
+			width = excluded.width,
+			height = excluded.height,
+			length = excluded.length,
+			grams = excluded.grams;
+	`
+	_, err := db.handle.Exec(
+		query,
+		defaults.Shop,
+		defaults.Width,
+		defaults.Height,
+		defaults.Length,
+		defaults.Grams,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetDimensionDefaults(shop string) (*DimensionDefaults, error) {
+	query := `
+		SELECT shop, width, height, length, grams
+		FROM dimension_defaults
+		WHERE shop = ?;
+	`
+	defaults := &DimensionDefaults{}
+	if err := db.handle.QueryRow(query, shop).Scan(
+		&defaults.Shop,
+		&defaults.Width,
+		&defaults.Height,
+		&defaults.Length,
+		&defaults.Grams,
+	); err != nil {
+		return nil, err
+	}
+	return defaults, nil
+}
+
+// DimensionIssue records why the dimensions of a product, or of one of its
+// variants when VariantID is not zero, could not be used.
+type DimensionIssue struct {
+	Shop      string    `json:"shop"`
+	ProductID int64     `json:"product_id"`
+	VariantID int64     `json:"variant_id"`
+	Field     string    `json:"field"`
+	Message   string    `json:"message"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (db *Database) UpsertDimensionIssue(issue *DimensionIssue) error {
+	query := `
+		INSERT INTO dimension_issues (
+			shop, product_id, variant_id, field, message, updated_at
+		) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (shop, product_id, variant_id) DO UPDATE SET
+			field = excluded.field,
+			message = excluded.message,
+			updated_at = excluded.updated_at;
+	`
+	_, err := db.handle.Exec(
+		query,
+		issue.Shop,
+		issue.ProductID,
+		issue.VariantID,
+		issue.Field,
+		issue.Message,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeleteDimensionIssue(shop string, productID, variantID int64) error {
+	query := `DELETE FROM dimension_issues WHERE shop = ? AND product_id = ? AND variant_id = ?;`
+	_, err := db.handle.Exec(query, shop, productID, variantID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetDimensionIssue(shop string, productID, variantID int64) (*DimensionIssue, error) {
+	query := `
+		SELECT shop, product_id, variant_id, field, message, updated_at
+		FROM dimension_issues
+		WHERE shop = ? AND product_id = ? AND variant_id = ?;
+	`
+	issue := &DimensionIssue{}
+	if err := db.handle.QueryRow(query, shop, productID, variantID).Scan(
+		&issue.Shop,
+		&issue.ProductID,
+		&issue.VariantID,
+		&issue.Field,
+		&issue.Message,
+		&issue.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+func (db *Database) GetDimensionIssues(shop string) ([]DimensionIssue, error) {
+	query := `
+		SELECT shop, product_id, variant_id, field, message, updated_at
+		FROM dimension_issues
+		WHERE shop = ?
+		ORDER BY updated_at DESC;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issues := []DimensionIssue{}
+
+	for rows.Next() {
+		issue := DimensionIssue{}
+		if err := rows.Scan(
+			&issue.Shop,
+			&issue.ProductID,
+			&issue.VariantID,
+			&issue.Field,
+			&issue.Message,
+			&issue.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+
+	return issues, nil
+}
+
+type CarrierSettings struct {
+	Shop            string    `json:"shop"`
+	ClientCode      string    `json:"client_code"`
+	Username        string    `json:"username"`
+	Password        string    `json:"password,omitempty"`
+	AccessToken     string    `json:"access_token,omitempty"`
+	ContractHome    string    `json:"contract_home"`
+	ContractBranch  string    `json:"contract_branch"`
+	ContractExpress string    `json:"contract_express"`
+	OriginBranch    string    `json:"origin_branch"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// MarshalJSON leaves the credentials out, they are only decoded from the
+// settings requests and never sent back.
+func (s CarrierSettings) MarshalJSON() ([]byte, error) {
+	type settings CarrierSettings
+	out := settings(s)
+	out.Password = ""
+	out.AccessToken = ""
+	return json.Marshal(out)
+}
+
+func (db *Database) InsertCarrierSettings(settings *CarrierSettings) error {
+	password, err := db.seal(settings.Password)
+	if err != nil {
+		return err
+	}
+	accessToken, err := db.seal(settings.AccessToken)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO carrier_settings (
+			shop, client_code, username, password, access_token,
+			contract_home, contract_branch, contract_express,
+			origin_branch
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	_, err = db.handle.Exec(
+		query,
+		settings.Shop,
+		settings.ClientCode,
+		settings.Username,
+		password,
+		accessToken,
+		settings.ContractHome,
+		settings.ContractBranch,
+		settings.ContractExpress,
+		settings.OriginBranch,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) UpdateCarrierSettings(settings *CarrierSettings) error {
+	password, err := db.seal(settings.Password)
+	if err != nil {
+		return err
+	}
+	accessToken, err := db.seal(settings.AccessToken)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE carrier_settings SET
+			client_code = ?,
+			username = ?,
+			password = ?,
+			access_token = ?,
+			contract_home = ?,
+			contract_branch = ?,
+			contract_express = ?,
+			origin_branch = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE shop = ?;
+	`
+	res, err := db.handle.Exec(
+		query,
+		settings.ClientCode,
+		settings.Username,
+		password,
+		accessToken,
+		settings.ContractHome,
+		settings.ContractBranch,
+		settings.ContractExpress,
+		settings.OriginBranch,
+		settings.Shop,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) GetCarrierSettings(shop string) (*CarrierSettings, error) {
+	query := `
+		SELECT
+			shop, client_code, username, password, access_token,
+			contract_home, contract_branch, contract_express,
+			origin_branch, updated_at
+		FROM carrier_settings
+		WHERE shop = ?;
+	`
+	settings := &CarrierSettings{}
+	if err := db.handle.QueryRow(query, shop).Scan(
+		&settings.Shop,
+		&settings.ClientCode,
+		&settings.Username,
+		&settings.Password,
+		&settings.AccessToken,
+		&settings.ContractHome,
+		&settings.ContractBranch,
+		&settings.ContractExpress,
+		&settings.OriginBranch,
+		&settings.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	password, err := db.open(settings.Password)
+	if err != nil {
+		return nil, err
+	}
+	settings.Password = password
+	accessToken, err := db.open(settings.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	settings.AccessToken = accessToken
+	return settings, nil
+}
+
+func (db *Database) DeleteCarrierSettings(shop string) error {
+	query := `DELETE FROM carrier_settings WHERE shop = ?;`
+	_, err := db.handle.Exec(query, shop)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type RateQuote struct {
+	QuoteID     int64     `json:"quote_id"`
+	Shop        string    `json:"shop"`
+	ServiceCode string    `json:"service_code"`
+	Contract    string    `json:"contract"`
+	Zip         string    `json:"zip"`
+	Volume      float64   `json:"volume"`
+	Kilos       float64   `json:"kilos"`
+	PesoAforado string    `json:"peso_aforado"`
+	Total       string    `json:"total"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// InsertRateQuotes stores a batch of quotes in a single transaction.
+func (db *Database) InsertRateQuotes(quotes []RateQuote) error {
+	tx, err := db.handle.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO rate_quotes (
+			shop, service_code, contract, zip,
+			volume, kilos, peso_aforado, total
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	for _, quote := range quotes {
+		if _, err := tx.Exec(
+			query,
+			quote.Shop,
+			quote.ServiceCode,
+			quote.Contract,
+			quote.Zip,
+			quote.Volume,
+			quote.Kilos,
+			quote.PesoAforado,
+			quote.Total,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRateQuotesBefore removes the quotes older than before and returns how
+// many were removed.
+func (db *Database) DeleteRateQuotesBefore(before time.Time) (int64, error) {
+	query := `DELETE FROM rate_quotes WHERE created_at < datetime(?, 'unixepoch');`
+	res, err := db.handle.Exec(query, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Box dimensions are the inner dimensions in cm, weights are in kg.
+type Box struct {
+	BoxID     int64   `json:"box_id"`
+	Shop      string  `json:"shop"`
+	Name      string  `json:"name"`
+	Width     float64 `json:"width"`
+	Height    float64 `json:"height"`
+	Length    float64 `json:"length"`
+	MaxKilos  float64 `json:"max_kilos"`
+	TareKilos float64 `json:"tare_kilos"`
+}
+
+func (db *Database) InsertBox(box *Box) error {
+	query := `
+		INSERT INTO boxes (
+			shop, name, width, height, length, max_kilos, tare_kilos
+		) VALUES (?, ?, ?, ?, ?, ?, ?);
+	`
+	res, err := db.handle.Exec(
+		query,
+		box.Shop,
+		box.Name,
+		box.Width,
+		box.Height,
+		box.Length,
+		box.MaxKilos,
+		box.TareKilos,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	box.BoxID = id
+	return nil
+}
+
+func (db *Database) UpdateBox(box *Box) error {
+	query := `
+		UPDATE boxes SET
+			name = ?,
+			width = ?,
+			height = ?,
+			length = ?,
+			max_kilos = ?,
+			tare_kilos = ?
+		WHERE box_id = ? AND shop = ?;
+	`
+	res, err := db.handle.Exec(
+		query,
+		box.Name,
+		box.Width,
+		box.Height,
+		box.Length,
+		box.MaxKilos,
+		box.TareKilos,
+		box.BoxID,
+		box.Shop,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) DeleteBox(shop string, boxID int64) error {
+	query := `DELETE FROM boxes WHERE box_id = ? AND shop = ?;`
+	_, err := db.handle.Exec(query, boxID, shop)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetBoxes(shop string) ([]Box, error) {
+	query := `
+		SELECT box_id, shop, name, width, height, length, max_kilos, tare_kilos
+		FROM boxes
+		WHERE shop = ?
+		ORDER BY box_id;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boxes := []Box{}
+
+	for rows.Next() {
+		box := Box{}
+		if err := rows.Scan(
+			&box.BoxID,
+			&box.Shop,
+			&box.Name,
+			&box.Width,
+			&box.Height,
+			&box.Length,
+			&box.MaxKilos,
+			&box.TareKilos,
+		); err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+	}
+
+	return boxes, nil
+}
+
+// RateRule changes the rates returned to the checkout. Nil conditions match
+// every cart, money amounts are stored in cents like the orders.
+type RateRule struct {
+	RuleID      int64   `json:"rule_id"`
+	Shop        string  `json:"shop"`
+	Name        string  `json:"name"`
+	Priority    int     `json:"priority"`
+	Action      string  `json:"action"`
+	Value       float64 `json:"value"`
+	ServiceCode *string `json:"service_code"`
+	Province    *string `json:"province"`
+	ZipFrom     *int64  `json:"zip_from"`
+	ZipTo       *int64  `json:"zip_to"`
+	MinSubtotal *int64  `json:"min_subtotal"`
+	MaxSubtotal *int64  `json:"max_subtotal"`
+	SkuPrefix   *string `json:"sku_prefix"`
+	Active      bool    `json:"active"`
+}
+
+func (db *Database) InsertRateRule(rule *RateRule) error {
+	query := `
+		INSERT INTO rate_rules (
+			shop, name, priority, action, value,
+			service_code, province, zip_from, zip_to,
+			min_subtotal, max_subtotal, sku_prefix, active
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	res, err := db.handle.Exec(
+		query,
+		rule.Shop,
+		rule.Name,
+		rule.Priority,
+		rule.Action,
+		rule.Value,
+		rule.ServiceCode,
+		rule.Province,
+		rule.ZipFrom,
+		rule.ZipTo,
+		rule.MinSubtotal,
+		rule.MaxSubtotal,
+		rule.SkuPrefix,
+		rule.Active,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	rule.RuleID = id
+	return nil
+}
+
+func (db *Database) UpdateRateRule(rule *RateRule) error {
+	query := `
+		UPDATE rate_rules SET
+			name = ?,
+			priority = ?,
+			action = ?,
+			value = ?,
+			service_code = ?,
+			province = ?,
+			zip_from = ?,
+			zip_to = ?,
+			min_subtotal = ?,
+			max_subtotal = ?,
+			sku_prefix = ?,
+			active = ?
+		WHERE rule_id = ? AND shop = ?;
+	`
+	res, err := db.handle.Exec(
+		query,
+		rule.Name,
+		rule.Priority,
+		rule.Action,
+		rule.Value,
+		rule.ServiceCode,
+		rule.Province,
+		rule.ZipFrom,
+		rule.ZipTo,
+		rule.MinSubtotal,
+		rule.MaxSubtotal,
+		rule.SkuPrefix,
+		rule.Active,
+		rule.RuleID,
+		rule.Shop,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) DeleteRateRule(shop string, ruleID int64) error {
+	query := `DELETE FROM rate_rules WHERE rule_id = ? AND shop = ?;`
+	_, err := db.handle.Exec(query, ruleID, shop)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetRateRules(shop string) ([]RateRule, error) {
+	query := `
+		SELECT
+			rule_id, shop, name, priority, action, value,
+			service_code, province, zip_from, zip_to,
+			min_subtotal, max_subtotal, sku_prefix, active
+		FROM rate_rules
+		WHERE shop = ?
+		ORDER BY priority, rule_id;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RateRule{}
+
+	for rows.Next() {
+		rule := RateRule{}
+		if err := rows.Scan(
+			&rule.RuleID,
+			&rule.Shop,
+			&rule.Name,
+			&rule.Priority,
+			&rule.Action,
+			&rule.Value,
+			&rule.ServiceCode,
+			&rule.Province,
+			&rule.ZipFrom,
+			&rule.ZipTo,
+			&rule.MinSubtotal,
+			&rule.MaxSubtotal,
+			&rule.SkuPrefix,
+			&rule.Active,
+		); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// GetLastRateQuote returns the newest quote for a zip inside the given
+// volume (cm3) and weight (kg) ranges.
+func (db *Database) GetLastRateQuote(shop, serviceCode, zip string, minVolume, maxVolume, minKilos, maxKilos float64) (*RateQuote, error) {
+	query := `
+		SELECT
+			quote_id, shop, service_code, contract, zip,
+			volume, kilos, peso_aforado, total, created_at
+		FROM rate_quotes
+		WHERE
+			shop = ?
+			AND service_code = ?
+			AND zip = ?
+			AND volume > ? AND volume <= ?
+			AND kilos > ? AND kilos <= ?
+		ORDER BY created_at DESC, quote_id DESC
+		LIMIT 1;
+	`
+	quote := &RateQuote{}
+	if err := db.handle.QueryRow(
+		query,
+		shop, serviceCode, zip,
+		minVolume, maxVolume,
+		minKilos, maxKilos,
+	).Scan(
+		&quote.QuoteID,
+		&quote.Shop,
+		&quote.ServiceCode,
+		&quote.Contract,
+		&quote.Zip,
+		&quote.Volume,
+		&quote.Kilos,
+		&quote.PesoAforado,
+		&quote.Total,
+		&quote.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+type FallbackSettings struct {
+	Shop      string `json:"shop"`
+	Mode      string `json:"mode"`
+	TimeoutMs int64  `json:"timeout_ms"`
+}
+
+func (db *Database) UpsertFallbackSettings(settings *FallbackSettings) error {
+	query := `
+		INSERT INTO fallback_settings (shop, mode, timeout_ms) VALUES (?, ?, ?)
+		ON CONFLICT (shop) DO UPDATE SET
+			mode = excluded.mode,
+			timeout_ms = excluded.timeout_ms;
+	`
+	_, err := db.handle.Exec(query, settings.Shop, settings.Mode, settings.TimeoutMs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetFallbackSettings(shop string) (*FallbackSettings, error) {
+	query := `SELECT shop, mode, timeout_ms FROM fallback_settings WHERE shop = ?;`
+	settings := &FallbackSettings{}
+	if err := db.handle.QueryRow(query, shop).Scan(
+		&settings.Shop,
+		&settings.Mode,
+		&settings.TimeoutMs,
+	); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// FallbackRate is a flat price in cents for a province.
+type FallbackRate struct {
+	ServiceCode string `json:"service_code"`
+	Province    string `json:"province"`
+	Price       int64  `json:"price"`
+}
+
+func (db *Database) ReplaceFallbackRates(shop string, rates []FallbackRate) error {
+	tx, err := db.handle.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM fallback_rates WHERE shop = ?;`, shop); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO fallback_rates (shop, service_code, province, price)
+		VALUES (?, ?, ?, ?);
+	`
+	for _, rate := range rates {
+		if _, err := tx.Exec(query, shop, rate.ServiceCode, rate.Province, rate.Price); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *Database) GetFallbackRates(shop string) ([]FallbackRate, error) {
+	query := `
+		SELECT service_code, province, price
+		FROM fallback_rates
+		WHERE shop = ?
+		ORDER BY service_code, province;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []FallbackRate{}
+
+	for rows.Next() {
+		rate := FallbackRate{}
+		if err := rows.Scan(&rate.ServiceCode, &rate.Province, &rate.Price); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+func (db *Database) GetFallbackRate(shop, serviceCode, province string) (*FallbackRate, error) {
+	query := `
+		SELECT service_code, province, price
+		FROM fallback_rates
+		WHERE shop = ? AND service_code = ? AND province = ? COLLATE NOCASE;
+	`
+	rate := &FallbackRate{}
+	if err := db.handle.QueryRow(query, shop, serviceCode, province).Scan(
+		&rate.ServiceCode,
+		&rate.Province,
+		&rate.Price,
+	); err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
+
+type RateCacheEntry struct {
+	Key       string
+	Shop      string
+	Rate      string
+	ExpiresAt time.Time
+}
+
+func (db *Database) UpsertRateCacheEntry(entry *RateCacheEntry) error {
+	query := `
+		INSERT INTO rate_cache (cache_key, shop, rate, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (cache_key) DO UPDATE SET
+			shop = excluded.shop,
+			rate = excluded.rate,
+			expires_at = excluded.expires_at;
+	`
+	_, err := db.handle.Exec(query, entry.Key, entry.Shop, entry.Rate, entry.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetRateCacheEntry(key string) (*RateCacheEntry, error) {
+	query := `SELECT cache_key, shop, rate, expires_at FROM rate_cache WHERE cache_key = ?;`
+	entry := &RateCacheEntry{}
+	if err := db.handle.QueryRow(query, key).Scan(
+		&entry.Key,
+		&entry.Shop,
+		&entry.Rate,
+		&entry.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (db *Database) DeleteShopRateCache(shop string) error {
+	query := `DELETE FROM rate_cache WHERE shop = ?;`
+	_, err := db.handle.Exec(query, shop)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeleteExpiredRateCache(now time.Time) error {
+	query := `DELETE FROM rate_cache WHERE expires_at <= ?;`
+	_, err := db.handle.Exec(query, now)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeliverySettings holds the business days the shop needs before handing the
+// parcel to Andreani and the local time after which orders leave a day later.
+type DeliverySettings struct {
+	Shop         string `json:"shop"`
+	HandlingDays int    `json:"handling_days"`
+	Cutoff       string `json:"cutoff"`
+}
+
+func (db *Database) UpsertDeliverySettings(settings *DeliverySettings) error {
+	query := `
+		INSERT INTO delivery_settings (shop, handling_days, cutoff) VALUES (?, ?, ?)
+		ON CONFLICT (shop) DO UPDATE SET
+			handling_days = excluded.handling_days,
+			cutoff = excluded.cutoff;
+	`
+	_, err := db.handle.Exec(query, settings.Shop, settings.HandlingDays, settings.Cutoff)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetDeliverySettings(shop string) (*DeliverySettings, error) {
+	query := `SELECT shop, handling_days, cutoff FROM delivery_settings WHERE shop = ?;`
+	settings := &DeliverySettings{}
+	if err := db.handle.QueryRow(query, shop).Scan(
+		&settings.Shop,
+		&settings.HandlingDays,
+		&settings.Cutoff,
+	); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// SenderSettings is who Andreani shows as the sender of the shipments.
+type SenderSettings struct {
+	Shop  string `json:"shop"`
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+	Email string `json:"email"`
+}
+
+func (db *Database) UpsertSenderSettings(settings *SenderSettings) error {
+	query := `
+		INSERT INTO sender_settings (shop, name, phone, email) VALUES (?, ?, ?, ?)
+		ON CONFLICT (shop) DO UPDATE SET
+			name = excluded.name,
+			phone = excluded.phone,
+			email = excluded.email;
+	`
+	_, err := db.handle.Exec(query, settings.Shop, settings.Name, settings.Phone, settings.Email)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetSenderSettings(shop string) (*SenderSettings, error) {
+	query := `SELECT shop, name, phone, email FROM sender_settings WHERE shop = ?;`
+	settings := &SenderSettings{}
+	if err := db.handle.QueryRow(query, shop).Scan(
+		&settings.Shop,
+		&settings.Name,
+		&settings.Phone,
+		&settings.Email,
+	); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// TransitTime is the business days a service takes, nil filters match any
+// origin, province or zip.
+type TransitTime struct {
+	TransitID      int64   `json:"transit_id"`
+	ServiceCode    string  `json:"service_code"`
+	OriginProvince *string `json:"origin_province"`
+	Province       *string `json:"province"`
+	ZipFrom        *int64  `json:"zip_from"`
+	ZipTo          *int64  `json:"zip_to"`
+	MinDays        int     `json:"min_days"`
+	MaxDays        int     `json:"max_days"`
+}
+
+func (db *Database) ReplaceTransitTimes(shop string, times []TransitTime) error {
+	tx, err := db.handle.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM transit_times WHERE shop = ?;`, shop); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO transit_times (
+			shop, service_code, origin_province, province, zip_from, zip_to, min_days, max_days
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	for _, t := range times {
+		if _, err := tx.Exec(
+			query,
+			shop,
+			t.ServiceCode,
+			t.OriginProvince,
+			t.Province,
+			t.ZipFrom,
+			t.ZipTo,
+			t.MinDays,
+			t.MaxDays,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *Database) GetTransitTimes(shop string) ([]TransitTime, error) {
+	query := `
+		SELECT transit_id, service_code, origin_province, province, zip_from, zip_to, min_days, max_days
+		FROM transit_times
+		WHERE shop = ?
+		ORDER BY service_code, transit_id;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := []TransitTime{}
+
+	for rows.Next() {
+		t := TransitTime{}
+		if err := rows.Scan(
+			&t.TransitID,
+			&t.ServiceCode,
+			&t.OriginProvince,
+			&t.Province,
+			&t.ZipFrom,
+			&t.ZipTo,
+			&t.MinDays,
+			&t.MaxDays,
+		); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return times, nil
+}
+
+// Holiday is a non working day, Date is formatted as 2006-01-02.
+type Holiday struct {
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+func (db *Database) UpsertHoliday(holiday *Holiday) error {
+	query := `
+		INSERT INTO holidays (date, name) VALUES (?, ?)
+		ON CONFLICT (date) DO UPDATE SET name = excluded.name;
+	`
+	_, err := db.handle.Exec(query, holiday.Date, holiday.Name)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeleteHoliday(date string) error {
+	query := `DELETE FROM holidays WHERE date = ?;`
+	_, err := db.handle.Exec(query, date)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetHolidays() ([]Holiday, error) {
+	query := `SELECT date, name FROM holidays ORDER BY date;`
+
+	rows, err := db.handle.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holidays := []Holiday{}
+
+	for rows.Next() {
+		holiday := Holiday{}
+		if err := rows.Scan(&holiday.Date, &holiday.Name); err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, holiday)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return holidays, nil
+}
+
+// LocationOrigin is where Andreani picks up the parcels of a Shopify location,
+// either the postal address or the Branch the merchant drops them off at.
+type LocationOrigin struct {
+	Shop       string  `json:"shop"`
+	LocationID int64   `json:"location_id"`
+	Name       string  `json:"name"`
+	Street     *string `json:"street"`
+	Number     *string `json:"number"`
+	Zip        *string `json:"zip"`
+	City       *string `json:"city"`
+	Province   *string `json:"province"`
+	Branch     *string `json:"branch"`
+}
+
+func (db *Database) UpsertLocationOrigin(origin *LocationOrigin) error {
+	query := `
+		INSERT INTO location_origins (
+			shop, location_id, name, street, number, zip, city, province, branch
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (shop, location_id) DO UPDATE SET
+			name = excluded.name,
+			street = excluded.street,
+			number = excluded.number,
+			zip = excluded.zip,
+			city = excluded.city,
+			province = excluded.province,
+			branch = excluded.branch;
+	`
+	_, err := db.handle.Exec(
+		query,
+		origin.Shop,
+		origin.LocationID,
+		origin.Name,
+		origin.Street,
+		origin.Number,
+		origin.Zip,
+		origin.City,
+		origin.Province,
+		origin.Branch,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeleteLocationOrigin(shop string, locationID int64) error {
+	query := `DELETE FROM location_origins WHERE shop = ? AND location_id = ?;`
+	_, err := db.handle.Exec(query, shop, locationID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetLocationOrigin(shop string, locationID int64) (*LocationOrigin, error) {
+	query := `
+		SELECT shop, location_id, name, street, number, zip, city, province, branch
+		FROM location_origins
+		WHERE shop = ? AND location_id = ?;
+	`
+	origin := &LocationOrigin{}
+	if err := db.handle.QueryRow(query, shop, locationID).Scan(
+		&origin.Shop,
+		&origin.LocationID,
+		&origin.Name,
+		&origin.Street,
+		&origin.Number,
+		&origin.Zip,
+		&origin.City,
+		&origin.Province,
+		&origin.Branch,
+	); err != nil {
+		return nil, err
+	}
+	return origin, nil
+}
+
+func (db *Database) GetLocationOrigins(shop string) ([]LocationOrigin, error) {
+	query := `
+		SELECT shop, location_id, name, street, number, zip, city, province, branch
+		FROM location_origins
+		WHERE shop = ?
+		ORDER BY location_id;
+	`
+
+	rows, err := db.handle.Query(query, shop)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	origins := []LocationOrigin{}
+
+	for rows.Next() {
+		origin := LocationOrigin{}
+		if err := rows.Scan(
+			&origin.Shop,
+			&origin.LocationID,
+			&origin.Name,
+			&origin.Street,
+			&origin.Number,
+			&origin.Zip,
+			&origin.City,
+			&origin.Province,
+			&origin.Branch,
+		); err != nil {
+			return nil, err
+		}
+		origins = append(origins, origin)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return origins, nil
+}
+
+// Locality is a locality of the Andreani dataset, with one row per postal
+// code it serves.
+type Locality struct {
+	LocalityID string `json:"locality_id"`
+	Locality   string `json:"locality"`
+	Province   string `json:"province"`
+	Zip        string `json:"zip"`
+}
+
+// ReplaceLocalities swaps the whole cached dataset and records when it was
+// downloaded.
+func (db *Database) ReplaceLocalities(localities []Locality, syncedAt time.Time) error {
+	tx, err := db.handle.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM localities;`); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO localities (locality_id, locality, province, zip)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (locality_id, zip) DO NOTHING;
+	`
+	for _, l := range localities {
+		if _, err := tx.Exec(query, l.LocalityID, l.Locality, l.Province, l.Zip); err != nil {
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO localities_sync (sync_id, synced_at) VALUES (1, ?)
+		ON CONFLICT (sync_id) DO UPDATE SET
+			synced_at = excluded.synced_at;
+	`
+	if _, err := tx.Exec(query, syncedAt.UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLocalitiesSyncedAt returns sql.ErrNoRows when the dataset was never
+// downloaded.
+func (db *Database) GetLocalitiesSyncedAt() (time.Time, error) {
+	var syncedAt time.Time
+	query := `SELECT synced_at FROM localities_sync WHERE sync_id = 1;`
+	if err := db.handle.QueryRow(query).Scan(&syncedAt); err != nil {
+		return time.Time{}, err
+	}
+	return syncedAt, nil
+}
+
+func (db *Database) GetLocalitiesByZip(zip string) ([]Locality, error) {
+	query := `
+		SELECT locality_id, locality, province, zip
+		FROM localities
+		WHERE zip = ?
+		ORDER BY locality;
+	`
+
+	rows, err := db.handle.Query(query, zip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	localities := []Locality{}
+
+	for rows.Next() {
+		l := Locality{}
+		if err := rows.Scan(&l.LocalityID, &l.Locality, &l.Province, &l.Zip); err != nil {
+			return nil, err
+		}
+		localities = append(localities, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return localities, nil
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"io"
+	"log"
+	"errors"
+	"strings"
+
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/cipher"
+	"crypto/sha256"
+
+	"encoding/base64"
+)
+
+// sealedPrefix marks the values encrypted by seal, rows written before the
+// encryption was added or without a key don't have it.
+const sealedPrefix = "v1:"
+
+var ErrNoSecretKey = errors.New("carrier credentials are encrypted but SETTINGS_SECRET_KEY is not set")
+
+// newSecretCipher derives the AES key from SETTINGS_SECRET_KEY, without a key
+// it returns nil and credentials stay in plaintext.
+func newSecretCipher(key string) (cipher.AEAD, error) {
+	if key == "" {
+		log.Println("warning: SETTINGS_SECRET_KEY is not set, andreani credentials are stored in plaintext until it is")
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (db *Database) seal(plaintext string) (string, error) {
+	if db.secret == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	nonce := make([]byte, db.secret.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := db.secret.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a sealed value, plaintext values are returned as they are.
+func (db *Database) open(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if db.secret == nil {
+		return "", ErrNoSecretKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	size := db.secret.NonceSize()
+	if len(sealed) < size {
+		return "", errors.New("secret is truncated")
+	}
+	plaintext, err := db.secret.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", errors.New("secret can't be decrypted, wrong SETTINGS_SECRET_KEY?")
+	}
+	return string(plaintext), nil
+}
+
+// sealPlaintextCredentials encrypts the carrier passwords and access tokens
+// stored before the key was set.
+func (db *Database) sealPlaintextCredentials() error {
+	if db.secret == nil {
+		return nil
+	}
+
+	query := `
+		SELECT shop, password, access_token
+		FROM carrier_settings
+		WHERE password NOT LIKE 'v1:%'
+			OR (access_token != '' AND access_token NOT LIKE 'v1:%');
+	`
+	rows, err := db.handle.Query(query)
+	if err != nil {
+		return err
+	}
+
+	type credentials struct {
+		shop, password, accessToken string
+	}
+	plain := []credentials{}
+	for rows.Next() {
+		var c credentials
+		if err := rows.Scan(&c.shop, &c.password, &c.accessToken); err != nil {
+			rows.Close()
+			return err
+		}
+		plain = append(plain, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range plain {
+		password, err := db.sealOnce(c.password)
+		if err != nil {
+			return err
+		}
+		accessToken, err := db.sealOnce(c.accessToken)
+		if err != nil {
+			return err
+		}
+		query := `UPDATE carrier_settings SET password = ?, access_token = ? WHERE shop = ?;`
+		if _, err := db.handle.Exec(query, password, accessToken, c.shop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealOnce seals value unless it already is.
+func (db *Database) sealOnce(value string) (string, error) {
+	if strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	return db.seal(value)
+}
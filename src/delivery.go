@@ -0,0 +1,386 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"strings"
+	"strconv"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/database"
+)
+
+const (
+	defaultHandlingDays = 1
+	defaultCutoff       = "14:00"
+
+	deliveryDateLayout = "2006-01-02 15:04:05 -0700"
+)
+
+var argentina = loadArgentina()
+
+func loadArgentina() *time.Location {
+	loc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		// Argentina has no daylight saving, a fixed offset is enough when the
+		// system has no zoneinfo.
+		return time.FixedZone("ART", -3*60*60)
+	}
+	return loc
+}
+
+// fixedHolidays are the national holidays that never move, the movable ones
+// are loaded from the holidays table.
+var fixedHolidays = map[string]string{
+	"01-01": "Año Nuevo",
+	"03-24": "Día Nacional de la Memoria por la Verdad y la Justicia",
+	"04-02": "Día del Veterano y de los Caídos en la Guerra de Malvinas",
+	"05-01": "Día del Trabajador",
+	"05-25": "Día de la Revolución de Mayo",
+	"06-20": "Paso a la Inmortalidad del General Manuel Belgrano",
+	"07-09": "Día de la Independencia",
+	"12-08": "Inmaculada Concepción de María",
+	"12-25": "Navidad",
+}
+
+// easter returns easter sunday of the year (anonymous gregorian algorithm).
+func easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, argentina)
+}
+
+type deliveryCalendar struct {
+	holidays map[string]bool
+}
+
+func (app *Application) deliveryCalendar() (*deliveryCalendar, error) {
+	holidays, err := app.db.GetHolidays()
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := &deliveryCalendar{holidays: map[string]bool{}}
+	for _, holiday := range holidays {
+		calendar.holidays[holiday.Date] = true
+	}
+	return calendar, nil
+}
+
+func (c *deliveryCalendar) isHoliday(day time.Time) bool {
+	if _, ok := fixedHolidays[day.Format("01-02")]; ok {
+		return true
+	}
+
+	// carnival monday and tuesday and good friday depend on easter.
+	sunday := easter(day.Year())
+	for _, offset := range []int{-48, -47, -2} {
+		movable := sunday.AddDate(0, 0, offset)
+		if movable.Month() == day.Month() && movable.Day() == day.Day() {
+			return true
+		}
+	}
+
+	return c.holidays[day.Format("2006-01-02")]
+}
+
+func (c *deliveryCalendar) isBusinessDay(day time.Time) bool {
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+	return !c.isHoliday(day)
+}
+
+func (c *deliveryCalendar) nextBusinessDay(day time.Time) time.Time {
+	day = day.AddDate(0, 0, 1)
+	for !c.isBusinessDay(day) {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+func (c *deliveryCalendar) addBusinessDays(day time.Time, n int) time.Time {
+	for i := 0; i < n; i++ {
+		day = c.nextBusinessDay(day)
+	}
+	return day
+}
+
+// shipDate is the day the parcel is handed to Andreani for an order placed now.
+func (c *deliveryCalendar) shipDate(now time.Time, settings *database.DeliverySettings) time.Time {
+	now = now.In(argentina)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, argentina)
+
+	cutoff, err := time.Parse("15:04", settings.Cutoff)
+	if err != nil {
+		cutoff, _ = time.Parse("15:04", defaultCutoff)
+	}
+	afterCutoff := now.Hour()*60+now.Minute() >= cutoff.Hour()*60+cutoff.Minute()
+
+	if !c.isBusinessDay(day) || afterCutoff {
+		day = c.nextBusinessDay(day)
+	}
+	return c.addBusinessDays(day, settings.HandlingDays)
+}
+
+func (app *Application) deliverySettings(shop string) *database.DeliverySettings {
+	settings, err := app.db.GetDeliverySettings(shop)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err.Error())
+		}
+		settings = &database.DeliverySettings{
+			Shop:         shop,
+			HandlingDays: defaultHandlingDays,
+			Cutoff:       defaultCutoff,
+		}
+	}
+	return settings
+}
+
+func transitMatches(t *database.TransitTime, serviceCode, origin, province string, zip int64, zipErr error) bool {
+	if t.ServiceCode != serviceCode {
+		return false
+	}
+
+	if t.OriginProvince != nil && !strings.EqualFold(*t.OriginProvince, origin) {
+		return false
+	}
+
+	if t.Province != nil && !strings.EqualFold(*t.Province, province) {
+		return false
+	}
+
+	if t.ZipFrom != nil || t.ZipTo != nil {
+		if zipErr != nil {
+			return false
+		}
+		if t.ZipFrom != nil && zip < *t.ZipFrom {
+			return false
+		}
+		if t.ZipTo != nil && zip > *t.ZipTo {
+			return false
+		}
+	}
+
+	return true
+}
+
+// transitSpecificity prefers zip ranges over provinces and provinces over
+// rows that match any destination.
+func transitSpecificity(t *database.TransitTime) int {
+	score := 0
+	if t.OriginProvince != nil {
+		score += 1
+	}
+	if t.Province != nil {
+		score += 2
+	}
+	if t.ZipFrom != nil || t.ZipTo != nil {
+		score += 4
+	}
+	return score
+}
+
+func findTransitTime(times []database.TransitTime, serviceCode, origin, province, zip string) *database.TransitTime {
+	zipNumber, zipErr := strconv.ParseInt(postalCode(zip), 10, 64)
+
+	var found *database.TransitTime
+	for i := range times {
+		t := &times[i]
+		if !transitMatches(t, serviceCode, origin, province, zipNumber, zipErr) {
+			continue
+		}
+		if found == nil || transitSpecificity(t) > transitSpecificity(found) {
+			found = t
+		}
+	}
+	return found
+}
+
+// estimateDelivery fills the delivery dates of the rates that have a transit
+// time, the others are returned without an estimate.
+func (app *Application) estimateDelivery(shop string, origin string, req QuoteRequest, rates []CarrierRate) error {
+	times, err := app.db.GetTransitTimes(shop)
+	if err != nil {
+		return err
+	}
+
+	if len(times) == 0 {
+		return nil
+	}
+
+	calendar, err := app.deliveryCalendar()
+	if err != nil {
+		return err
+	}
+
+	ship := calendar.shipDate(time.Now(), app.deliverySettings(shop))
+
+	for i := range rates {
+		t := findTransitTime(times, rates[i].ServiceCode, origin, req.Province, req.Zip)
+		if t == nil {
+			continue
+		}
+		rates[i].MinDeliveryDate = calendar.addBusinessDays(ship, t.MinDays).Format(deliveryDateLayout)
+		rates[i].MaxDeliveryDate = calendar.addBusinessDays(ship, t.MaxDays).Format(deliveryDateLayout)
+	}
+	return nil
+}
+
+func (app *Application) GetDeliverySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings := app.deliverySettings(app.shop)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutDeliverySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var settings database.DeliverySettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if settings.HandlingDays < 0 {
+		http.Error(w, "invalid handling days", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := time.Parse("15:04", settings.Cutoff); err != nil {
+		http.Error(w, "invalid cutoff, expected HH:MM", http.StatusBadRequest)
+		return
+	}
+	settings.Shop = app.shop
+
+	if err := app.db.UpsertDeliverySettings(&settings); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) GetTransitTimesHandler(w http.ResponseWriter, r *http.Request) {
+	times, err := app.db.GetTransitTimes(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(times); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutTransitTimesHandler(w http.ResponseWriter, r *http.Request) {
+	var times []database.TransitTime
+	if err := json.NewDecoder(r.Body).Decode(&times); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	for _, t := range times {
+		if t.ServiceCode == "" || t.MinDays < 0 || t.MaxDays < t.MinDays {
+			http.Error(w, "invalid transit time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := app.db.ReplaceTransitTimes(app.shop, times); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *Application) GetHolidaysHandler(w http.ResponseWriter, r *http.Request) {
+	holidays, err := app.db.GetHolidays()
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(holidays); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) CreateHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	var holiday database.Holiday
+	if err := json.NewDecoder(r.Body).Decode(&holiday); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := time.Parse("2006-01-02", holiday.Date); err != nil {
+		http.Error(w, "invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	if holiday.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.db.UpsertHoliday(&holiday); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(holiday); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) DeleteHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	date := r.PathValue("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, "invalid date", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.db.DeleteHoliday(date); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+	"errors"
+
+	"net/http"
+	"sync/atomic"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/money"
+	"tomi/src/database"
+)
+
+const (
+	FallbackNone              = "none"
+	FallbackLastKnown         = "last_known"
+	FallbackProvince          = "province"
+	FallbackLastKnownProvince = "last_known_province"
+)
+
+const (
+	defaultQuoteTimeout = 3 * time.Second
+
+	volumeBucketSize = 1000
+	kilosBucketSize  = 1
+)
+
+type RateStats struct {
+	Fallbacks      atomic.Int64
+	FallbackMisses atomic.Int64
+}
+
+type fallbackSettings struct {
+	database.FallbackSettings
+}
+
+func (f *fallbackSettings) Timeout() time.Duration {
+	if f.TimeoutMs <= 0 {
+		return defaultQuoteTimeout
+	}
+	return time.Duration(f.TimeoutMs) * time.Millisecond
+}
+
+func (app *Application) fallbackSettings(shop string) *fallbackSettings {
+	settings, err := app.db.GetFallbackSettings(shop)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err.Error())
+		}
+		settings = &database.FallbackSettings{
+			Shop:      shop,
+			Mode:      FallbackLastKnownProvince,
+			TimeoutMs: defaultQuoteTimeout.Milliseconds(),
+		}
+	}
+	return &fallbackSettings{*settings}
+}
+
+// bucket returns the (min, max] range a value falls in.
+func bucket(value, size float64) (float64, float64) {
+	b := math.Ceil(value / size)
+	return (b - 1) * size, b * size
+}
+
+func (app *Application) lastKnownRate(shop string, service ShippingService, req QuoteRequest) *CarrierRate {
+	minVolume, maxVolume := bucket(req.Volume(), volumeBucketSize)
+	minKilos, maxKilos := bucket(req.Kilos(), kilosBucketSize)
+
+	quote, err := app.db.GetLastRateQuote(shop, service.Code, req.Zip, minVolume, maxVolume, minKilos, maxKilos)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err.Error())
+		}
+		return nil
+	}
+
+	price, err := money.Parse(quote.Total, money.DefaultCurrency)
+	if err != nil {
+		return nil
+	}
+	return service.rate(price)
+}
+
+func (app *Application) provinceRate(shop string, service ShippingService, req QuoteRequest) *CarrierRate {
+	fallback, err := app.db.GetFallbackRate(shop, service.Code, req.Province)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err.Error())
+		}
+		return nil
+	}
+	return service.rate(money.New(fallback.Price, money.DefaultCurrency))
+}
+
+// fallbackRate is used when Andreani fails or misses the deadline, it
+// returns nil when the shop has no fallback for the request.
+func (app *Application) fallbackRate(settings *fallbackSettings, service ShippingService, req QuoteRequest) *CarrierRate {
+	var rate *CarrierRate
+
+	switch settings.Mode {
+	case FallbackLastKnown:
+		rate = app.lastKnownRate(settings.Shop, service, req)
+	case FallbackProvince:
+		rate = app.provinceRate(settings.Shop, service, req)
+	case FallbackLastKnownProvince:
+		rate = app.lastKnownRate(settings.Shop, service, req)
+		if rate == nil {
+			rate = app.provinceRate(settings.Shop, service, req)
+		}
+	}
+
+	if rate == nil {
+		misses := app.rateStats.FallbackMisses.Add(1)
+		log.Printf("fallback %s: no rate for zip %s (%d misses)\n", service.Code, req.Zip, misses)
+		return nil
+	}
+
+	used := app.rateStats.Fallbacks.Add(1)
+	log.Printf("fallback %s: %s cents for zip %s (%d used)\n", service.Code, rate.TotalPrice, req.Zip, used)
+	return rate
+}
+
+func (app *Application) GetFallbackSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings := app.fallbackSettings(app.shop)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings.FallbackSettings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutFallbackSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var settings database.FallbackSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	switch settings.Mode {
+	case FallbackNone, FallbackLastKnown, FallbackProvince, FallbackLastKnownProvince:
+	default:
+		http.Error(w, "invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	if settings.TimeoutMs <= 0 {
+		http.Error(w, "invalid timeout", http.StatusBadRequest)
+		return
+	}
+	settings.Shop = app.shop
+
+	if err := app.db.UpsertFallbackSettings(&settings); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) GetFallbackRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := app.db.GetFallbackRates(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rates); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutFallbackRatesHandler(w http.ResponseWriter, r *http.Request) {
+	var rates []database.FallbackRate
+	if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	for _, rate := range rates {
+		if rate.ServiceCode == "" || rate.Province == "" || rate.Price < 0 {
+			http.Error(w, "invalid fallback rate", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := app.db.ReplaceFallbackRates(app.shop, rates); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *Application) GetRateStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := struct {
+		Fallbacks      int64 `json:"fallbacks"`
+		FallbackMisses int64 `json:"fallback_misses"`
+		CacheHits      int64 `json:"cache_hits"`
+		CacheMisses    int64 `json:"cache_misses"`
+	}{
+		Fallbacks:      app.rateStats.Fallbacks.Load(),
+		FallbackMisses: app.rateStats.FallbackMisses.Load(),
+		CacheHits:      app.rateCache.Hits.Load(),
+		CacheMisses:    app.rateCache.Misses.Load(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"bytes"
+	"errors"
+	"context"
+	"strconv"
+	"time"
+
+	"net/http"
+
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+
+	"tomi/src/pdf"
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+var ErrLabelNotFound = errors.New("label not found")
+
+func labelChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// shippingLabel returns the stored label of a package, or of the whole group
+// when packageNumber is empty, downloading it from Andreani the first time or
+// when the stored content doesn't match its checksum.
+func (app *Application) shippingLabel(ctx context.Context, api *andreani.Api, shipping *database.Shipping, packageNumber string) (*database.Label, error) {
+	stored, err := app.db.GetLabel(shipping.ShippingID, packageNumber)
+	if err == nil {
+		if labelChecksum(stored.Content) == stored.Sha256 {
+			return stored, nil
+		}
+		log.Printf("shipping %d: label %q checksum mismatch, downloading again\n", shipping.ShippingID, packageNumber)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var downloaded *andreani.Label
+	if packageNumber == "" {
+		if shipping.PackageGroup == "" {
+			return nil, ErrLabelNotFound
+		}
+		downloaded, err = api.GetGroupLabel(ctx, shipping.PackageGroup)
+	} else {
+		link := ""
+		for _, pkg := range shipping.Packages {
+			if pkg.Number == packageNumber {
+				link = pkg.Label
+				break
+			}
+		}
+		if link == "" {
+			return nil, ErrLabelNotFound
+		}
+		downloaded, err = api.GetPackageLabel(ctx, link)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	label := &database.Label{
+		ShippingID:    shipping.ShippingID,
+		PackageNumber: packageNumber,
+		ContentType:   downloaded.ContentType,
+		Content:       downloaded.Content,
+		Sha256:        labelChecksum(downloaded.Content),
+	}
+
+	if err := app.db.UpsertLabel(label); err != nil {
+		return nil, err
+	}
+
+	return label, nil
+}
+
+// GetShipmentLabelsHandler streams the labels of a shipment, the whole group
+// by default or a single package with ?package=<number>.
+func (app *Application) GetShipmentLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	shipping, err := app.db.GetShipping(app.shop, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "shipment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	packageNumber := r.URL.Query().Get("package")
+	if packageNumber == "" && shipping.PackageGroup == "" && len(shipping.Packages) == 1 {
+		packageNumber = shipping.Packages[0].Number
+	}
+
+	api, _, err := app.andreaniApi(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "andreani is not configured", http.StatusConflict)
+		return
+	}
+
+	label, err := app.shippingLabel(r.Context(), api, shipping, packageNumber)
+	if errors.Is(err, ErrLabelNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "andreani label failed", http.StatusBadGateway)
+		return
+	}
+
+	name := fmt.Sprintf("shipment-%d.pdf", shipping.ShippingID)
+	if packageNumber != "" {
+		name = fmt.Sprintf("shipment-%d-%s.pdf", shipping.ShippingID, packageNumber)
+	}
+
+	w.Header().Set("Content-Type", label.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	w.Header().Set("ETag", strconv.Quote(label.Sha256))
+	http.ServeContent(w, r, name, label.CreatedAt, bytes.NewReader(label.Content))
+}
+
+// shipmentLabelFiles returns the label of the package group, or of every
+// package when Andreani didn't group them.
+func (app *Application) shipmentLabelFiles(ctx context.Context, api *andreani.Api, shipping *database.Shipping) ([][]byte, error) {
+	if shipping.PackageGroup != "" {
+		label, err := app.shippingLabel(ctx, api, shipping, "")
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{label.Content}, nil
+	}
+
+	files := [][]byte{}
+	for _, pkg := range shipping.Packages {
+		label, err := app.shippingLabel(ctx, api, shipping, pkg.Number)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, label.Content)
+	}
+	return files, nil
+}
+
+// BatchLabelsHandler merges the labels of many shipments, or of every
+// shipment created today, into a single PDF to print at once.
+func (app *Application) BatchLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ShipmentIDs []int64 `json:"shipment_ids"`
+		Today       bool    `json:"today"`
+		Layout      string  `json:"layout"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Layout == "" {
+		payload.Layout = pdf.LayoutMerge.Name
+	}
+
+	layout, ok := pdf.Layouts[payload.Layout]
+	if !ok {
+		http.Error(w, "invalid layout", http.StatusBadRequest)
+		return
+	}
+
+	ids := payload.ShipmentIDs
+	if payload.Today {
+		now := time.Now().In(argentina)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, argentina)
+
+		created, err := app.db.GetShippingsCreatedSince(app.shop, today)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, created...)
+	}
+
+	if len(ids) == 0 {
+		http.Error(w, "no shipments selected", http.StatusBadRequest)
+		return
+	}
+
+	api, _, err := app.andreaniApi(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "andreani is not configured", http.StatusConflict)
+		return
+	}
+
+	files := [][]byte{}
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		shipping, err := app.db.GetShipping(app.shop, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("shipment %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		labels, err := app.shipmentLabelFiles(r.Context(), api, shipping)
+		if err != nil {
+			log.Printf("shipping %d: %s\n", id, err.Error())
+			http.Error(w, fmt.Sprintf("labels of shipment %d failed", id), http.StatusBadGateway)
+			return
+		}
+		files = append(files, labels...)
+	}
+
+	var merged bytes.Buffer
+	if err := pdf.Merge(&merged, files, layout); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "labels could not be merged", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "labels-"+layout.Name+".pdf"))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(merged.Bytes()); err != nil {
+		log.Println(err.Error())
+	}
+}
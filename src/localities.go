@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"time"
+	"errors"
+	"strings"
+
+	"database/sql"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+// The localidades dataset barely changes, a weekly download is enough.
+const defaultLocalitiesInterval = 7 * 24 * time.Hour
+
+func localitiesInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("LOCALITIES_SYNC_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return defaultLocalitiesInterval
+	}
+	return interval
+}
+
+// SyncLocalities downloads the Andreani localidades and replaces the cached
+// copy, returning the number of locality and postal code pairs stored.
+func (app *Application) SyncLocalities() (int, error) {
+	api, _, err := app.andreaniApi(app.shop)
+	if err != nil {
+		return 0, err
+	}
+
+	locations, err := api.GetLocations(andreani.LocationQuery{})
+	if err != nil {
+		return 0, err
+	}
+
+	localities := []database.Locality{}
+	for _, location := range locations {
+		for _, zip := range location.CondigosPostales {
+			localities = append(localities, database.Locality{
+				LocalityID: location.IdDeProvLocalidad,
+				Locality:   location.Localidad,
+				Province:   location.Provincia,
+				Zip:        postalCode(zip),
+			})
+		}
+	}
+
+	// An empty answer would wipe the cache and flag every order.
+	if len(localities) == 0 {
+		return 0, errors.New("andreani returned no localities")
+	}
+
+	if err := app.db.ReplaceLocalities(localities, time.Now()); err != nil {
+		return 0, err
+	}
+
+	return len(localities), nil
+}
+
+func (app *Application) localitiesStale(interval time.Duration) (bool, error) {
+	syncedAt, err := app.db.GetLocalitiesSyncedAt()
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Since(syncedAt) >= interval, nil
+}
+
+// RunLocalitiesSync checks every hour whether the cached dataset is older
+// than interval, so restarts don't download it again.
+func (app *Application) RunLocalitiesSync(interval time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		stale, err := app.localitiesStale(interval)
+		if err != nil {
+			log.Printf("localities sync failed: %s\n", err.Error())
+		} else if stale {
+			n, err := app.SyncLocalities()
+			if err != nil {
+				log.Printf("localities sync failed: %s\n", err.Error())
+			} else {
+				log.Printf("localities sync finished: %d localities\n", n)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// canonicalProvince reconciles the Shopify and Andreani names of the city of
+// Buenos Aires and Tierra del Fuego.
+func canonicalProvince(province string) string {
+	normalized := normalizeText(province)
+	switch {
+	case normalized == "caba",
+		strings.Contains(normalized, "capital federal"),
+		strings.Contains(normalized, "ciudad autonoma"),
+		normalized == "ciudad de buenos aires":
+		return "caba"
+	case strings.HasPrefix(normalized, "tierra del fuego"):
+		return "tierra del fuego"
+	}
+	return normalized
+}
+
+func sameLocality(a, b string) bool {
+	a = normalizeText(a)
+	b = normalizeText(b)
+	return a != "" && b != "" && (strings.Contains(a, b) || strings.Contains(b, a))
+}
+
+// addressIssues checks the postal code, locality and province of an order
+// address against the cached Andreani localidades. Only the postal code
+// format is checked while the dataset was never downloaded.
+func (app *Application) addressIssues(address *database.Address) (ValidationErrors, error) {
+	issues := ValidationErrors{}
+
+	if address == nil {
+		issues["shipping_address"] = "order has no shipping address"
+		return issues, nil
+	}
+
+	raw := addressValue(address.Zip)
+	zip := postalCode(raw)
+	if len(zip) != 4 {
+		issues["shipping_address.zip"] = fmt.Sprintf("%q is not a valid postal code", raw)
+		return issues, nil
+	}
+
+	if _, err := app.db.GetLocalitiesSyncedAt(); errors.Is(err, sql.ErrNoRows) {
+		return issues, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	localities, err := app.db.GetLocalitiesByZip(zip)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(localities) == 0 {
+		issues["shipping_address.zip"] = fmt.Sprintf("postal code %s is not served by andreani", zip)
+		return issues, nil
+	}
+
+	if province := addressValue(address.Province); province != "" {
+		matched := []database.Locality{}
+		for _, l := range localities {
+			if canonicalProvince(l.Province) == canonicalProvince(province) {
+				matched = append(matched, l)
+			}
+		}
+		if len(matched) == 0 {
+			issues["shipping_address.province"] = fmt.Sprintf("province %q doesn't match postal code %s, expected %s", province, zip, localities[0].Province)
+			return issues, nil
+		}
+		localities = matched
+	}
+
+	// The city of Buenos Aires is split in neighborhoods, customers write
+	// the city instead.
+	if canonicalProvince(localities[0].Province) == "caba" {
+		return issues, nil
+	}
+
+	city := addressValue(address.City)
+	for _, l := range localities {
+		if sameLocality(l.Locality, city) {
+			return issues, nil
+		}
+	}
+
+	names := []string{}
+	for _, l := range localities {
+		if len(names) == 5 {
+			break
+		}
+		names = append(names, l.Locality)
+	}
+	issues["shipping_address.city"] = fmt.Sprintf("city %q doesn't match postal code %s, expected one of: %s", city, zip, strings.Join(names, ", "))
+
+	return issues, nil
+}
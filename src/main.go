@@ -17,7 +17,7 @@ import (
 func cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	  w.Header().Set("Access-Control-Allow-Origin", "*")
-	  w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	  w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 	  w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 	  if r.Method == "OPTIONS" {
 	    w.WriteHeader(http.StatusOK)
@@ -124,6 +124,8 @@ func main() {
 	
 	http.HandleFunc("/webhooks/app-uninstalled", app.AppUninstalledWebHook)
 	http.HandleFunc("/webhooks/orders", app.OrdersWebhook)
+	http.HandleFunc("/webhooks/bulk-operations", app.BulkOperationsWebhook)
+	http.HandleFunc("/webhooks/products", app.ProductsWebhook)
 
 	fs := http.FileServer(http.Dir("./app_bridge/dist"))
 	http.Handle("/app_bridge/assets/", http.StripPrefix("/app_bridge/", fs))
@@ -137,6 +139,211 @@ func main() {
 		shopifyAuth(http.HandlerFunc(app.GetOrdersHandler)),
 	)
 
+	http.Handle(
+		"POST /api/orders/backfill",
+		shopifyAuth(http.HandlerFunc(app.BackfillOrdersHandler)),
+	)
+
+	http.Handle(
+		"POST /api/products/dimensions/sync",
+		shopifyAuth(http.HandlerFunc(app.SyncProductDimensionsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/products/dimension-issues",
+		shopifyAuth(http.HandlerFunc(app.GetDimensionIssuesHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/dimensions",
+		shopifyAuth(http.HandlerFunc(app.GetDimensionDefaultsHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/dimensions",
+		shopifyAuth(http.HandlerFunc(app.PutDimensionDefaultsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/andreani",
+		shopifyAuth(http.HandlerFunc(app.GetAndreaniSettingsHandler)),
+	)
+
+	http.Handle(
+		"POST /api/settings/andreani",
+		shopifyAuth(http.HandlerFunc(app.CreateAndreaniSettingsHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/andreani",
+		shopifyAuth(http.HandlerFunc(app.UpdateAndreaniSettingsHandler)),
+	)
+
+	http.Handle(
+		"DELETE /api/settings/andreani",
+		shopifyAuth(http.HandlerFunc(app.DeleteAndreaniSettingsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/sender",
+		shopifyAuth(http.HandlerFunc(app.GetSenderSettingsHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/sender",
+		shopifyAuth(http.HandlerFunc(app.PutSenderSettingsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/boxes",
+		shopifyAuth(http.HandlerFunc(app.GetBoxesHandler)),
+	)
+
+	http.Handle(
+		"POST /api/settings/boxes",
+		shopifyAuth(http.HandlerFunc(app.CreateBoxHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/boxes/{boxID}",
+		shopifyAuth(http.HandlerFunc(app.UpdateBoxHandler)),
+	)
+
+	http.Handle(
+		"DELETE /api/settings/boxes/{boxID}",
+		shopifyAuth(http.HandlerFunc(app.DeleteBoxHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/rules",
+		shopifyAuth(http.HandlerFunc(app.GetRateRulesHandler)),
+	)
+
+	http.Handle(
+		"POST /api/settings/rules",
+		shopifyAuth(http.HandlerFunc(app.CreateRateRuleHandler)),
+	)
+
+	http.Handle(
+		"POST /api/settings/rules/test",
+		shopifyAuth(http.HandlerFunc(app.TestRateRulesHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/rules/{ruleID}",
+		shopifyAuth(http.HandlerFunc(app.UpdateRateRuleHandler)),
+	)
+
+	http.Handle(
+		"DELETE /api/settings/rules/{ruleID}",
+		shopifyAuth(http.HandlerFunc(app.DeleteRateRuleHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/fallback",
+		shopifyAuth(http.HandlerFunc(app.GetFallbackSettingsHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/fallback",
+		shopifyAuth(http.HandlerFunc(app.PutFallbackSettingsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/fallback/rates",
+		shopifyAuth(http.HandlerFunc(app.GetFallbackRatesHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/fallback/rates",
+		shopifyAuth(http.HandlerFunc(app.PutFallbackRatesHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/delivery",
+		shopifyAuth(http.HandlerFunc(app.GetDeliverySettingsHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/delivery",
+		shopifyAuth(http.HandlerFunc(app.PutDeliverySettingsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/transit-times",
+		shopifyAuth(http.HandlerFunc(app.GetTransitTimesHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/transit-times",
+		shopifyAuth(http.HandlerFunc(app.PutTransitTimesHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/holidays",
+		shopifyAuth(http.HandlerFunc(app.GetHolidaysHandler)),
+	)
+
+	http.Handle(
+		"POST /api/settings/holidays",
+		shopifyAuth(http.HandlerFunc(app.CreateHolidayHandler)),
+	)
+
+	http.Handle(
+		"DELETE /api/settings/holidays/{date}",
+		shopifyAuth(http.HandlerFunc(app.DeleteHolidayHandler)),
+	)
+
+	http.Handle(
+		"GET /api/settings/locations",
+		shopifyAuth(http.HandlerFunc(app.GetLocationOriginsHandler)),
+	)
+
+	http.Handle(
+		"PUT /api/settings/locations/{locationID}",
+		shopifyAuth(http.HandlerFunc(app.PutLocationOriginHandler)),
+	)
+
+	http.Handle(
+		"DELETE /api/settings/locations/{locationID}",
+		shopifyAuth(http.HandlerFunc(app.DeleteLocationOriginHandler)),
+	)
+
+	http.Handle(
+		"GET /api/stats/rates",
+		shopifyAuth(http.HandlerFunc(app.GetRateStatsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/orders/{orderID}/shipments",
+		shopifyAuth(http.HandlerFunc(app.GetOrderShipmentsHandler)),
+	)
+
+	http.Handle(
+		"POST /api/orders/{orderID}/shipments",
+		shopifyAuth(http.HandlerFunc(app.CreateOrderShipmentHandler)),
+	)
+
+	http.Handle(
+		"DELETE /api/shipments/{id}",
+		shopifyAuth(http.HandlerFunc(app.CancelShipmentHandler)),
+	)
+
+	http.Handle(
+		"GET /api/shipments/{id}/labels",
+		shopifyAuth(http.HandlerFunc(app.GetShipmentLabelsHandler)),
+	)
+
+	http.Handle(
+		"GET /api/shipments/{id}/tracking",
+		shopifyAuth(http.HandlerFunc(app.GetShipmentTrackingHandler)),
+	)
+
+	http.Handle(
+		"POST /api/labels/batch",
+		shopifyAuth(http.HandlerFunc(app.BatchLabelsHandler)),
+	)
+
 	http.Handle(
 		"GET /api/orders/{orderID}/fulfillments",
 		shopifyAuth(http.HandlerFunc(app.GetOrderFulfillmentsHandler)),
@@ -0,0 +1,176 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"errors"
+	"strings"
+	"strconv"
+)
+
+const DefaultCurrency = "ARS"
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+var ErrOverflow = errors.New("amount overflows")
+
+var ErrCurrencyMismatch = errors.New("currencies don't match")
+
+// currencies without two decimals, any other code uses two.
+var minorDigits = map[string]int{
+	"CLP": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"PYG": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Money is an amount in the minor unit of its currency, 1234.56 ARS is 123456.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Digits returns how many decimals the currency has.
+func Digits(currency string) int {
+	if digits, ok := minorDigits[strings.ToUpper(currency)]; ok {
+		return digits
+	}
+	return 2
+}
+
+// thousands removes the "," separators of the whole part, each group after
+// the first one must have three digits.
+func thousands(whole string) (string, bool) {
+	if !strings.Contains(whole, ",") {
+		return whole, true
+	}
+	groups := strings.Split(whole, ",")
+	if len(groups[0]) == 0 || len(groups[0]) > 3 {
+		return "", false
+	}
+	for _, group := range groups[1:] {
+		if len(group) != 3 {
+			return "", false
+		}
+	}
+	return strings.Join(groups, ""), true
+}
+
+// Parse reads a decimal string like "1234.5" or "1,234.50" without going
+// through floats, extra decimals are rounded half away from zero.
+func Parse(amount string, currency string) (Money, error) {
+	s := strings.TrimSpace(amount)
+
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	whole, ok := thousands(whole)
+	if !ok || (whole == "" && frac == "") {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+			}
+		}
+	}
+
+	digits := Digits(currency)
+
+	roundUp := false
+	if len(frac) > digits {
+		roundUp = frac[digits] >= '5'
+		frac = frac[:digits]
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return Money{}, fmt.Errorf("%w: %q", ErrOverflow, amount)
+	}
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+
+	if roundUp {
+		if minor == math.MaxInt64 {
+			return Money{}, fmt.Errorf("%w: %q", ErrOverflow, amount)
+		}
+		minor++
+	}
+
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// String formats the amount as a decimal without the currency, "1234.56".
+func (m Money) String() string {
+	digits := Digits(m.Currency)
+
+	// The magnitude goes through uint64, negating MinInt64 overflows.
+	amount := uint64(m.Amount)
+	sign := ""
+	if m.Amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	s := strconv.FormatUint(amount, 10)
+	if digits == 0 {
+		return sign + s
+	}
+
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+// Minor returns the amount in minor units as a string, the format Shopify
+// expects for carrier rates.
+func (m Money) Minor() string {
+	return strconv.FormatInt(m.Amount, 10)
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: cannot add %s to %s", ErrCurrencyMismatch, other.Currency, m.Currency)
+	}
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Amount: 0, Currency: m.Currency}, nil
+	}
+	product := m.Amount * n
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
@@ -0,0 +1,192 @@
+package money
+
+import (
+	"math"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"whole", "1234", "ARS", 123400, nil},
+		{"one decimal", "1234.5", "ARS", 123450, nil},
+		{"two decimals", "1234.56", "ARS", 123456, nil},
+		{"spaces", "  12.30 ", "ARS", 1230, nil},
+		{"plus sign", "+1.00", "ARS", 100, nil},
+		{"no whole part", ".5", "ARS", 50, nil},
+		{"no decimals after point", "7.", "ARS", 700, nil},
+		{"zero", "0.00", "ARS", 0, nil},
+		{"negative", "-12.34", "ARS", -1234, nil},
+		{"negative rounds away from zero", "-0.005", "ARS", -1, nil},
+		{"rounds half up", "0.005", "ARS", 1, nil},
+		{"rounds down", "0.0049", "ARS", 0, nil},
+		{"more decimals rounding up", "19.999", "ARS", 2000, nil},
+		{"more decimals rounding down", "1.2345", "ARS", 123, nil},
+		{"thousands", "1,234.50", "ARS", 123450, nil},
+		{"millions", "1,234,567.89", "ARS", 123456789, nil},
+		{"negative thousands", "-1,000", "ARS", -100000, nil},
+		{"zero decimal currency", "1500", "CLP", 1500, nil},
+		{"zero decimal currency rounds", "1500.5", "CLP", 1501, nil},
+		{"three decimal currency", "1.2345", "KWD", 1235, nil},
+		{"lower case currency", "1.5", "jpy", 2, nil},
+		{"max", "92233720368547758.07", "ARS", math.MaxInt64, nil},
+		{"min", "-92233720368547758.07", "ARS", -math.MaxInt64, nil},
+		{"empty", "", "ARS", 0, ErrInvalidAmount},
+		{"only sign", "-", "ARS", 0, ErrInvalidAmount},
+		{"only point", ".", "ARS", 0, ErrInvalidAmount},
+		{"letters", "12a.00", "ARS", 0, ErrInvalidAmount},
+		{"two points", "1.2.3", "ARS", 0, ErrInvalidAmount},
+		{"comma decimals", "1234,50", "ARS", 0, ErrInvalidAmount},
+		{"bad grouping", "12,34.50", "ARS", 0, ErrInvalidAmount},
+		{"leading comma", ",123", "ARS", 0, ErrInvalidAmount},
+		{"long first group", "1234,567", "ARS", 0, ErrInvalidAmount},
+		{"double sign", "--1", "ARS", 0, ErrInvalidAmount},
+		{"exponent", "1e3", "ARS", 0, ErrInvalidAmount},
+		{"overflow", "92233720368547758.08", "ARS", 0, ErrOverflow},
+		{"overflow by rounding", "92233720368547758.075", "ARS", 0, ErrOverflow},
+		{"overflow whole", "99999999999999999999", "CLP", 0, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.amount, tt.currency)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Parse(%q) error = %v, want %v", tt.amount, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) unexpected error: %v", tt.amount, err)
+			}
+			if got.Amount != tt.want || got.Currency != tt.currency {
+				t.Fatalf("Parse(%q) = %+v, want %d %s", tt.amount, got, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(123456, "ARS"), "1234.56"},
+		{New(5, "ARS"), "0.05"},
+		{New(50, "ARS"), "0.50"},
+		{New(0, "ARS"), "0.00"},
+		{New(-5, "ARS"), "-0.05"},
+		{New(-123456, "ARS"), "-1234.56"},
+		{New(1500, "CLP"), "1500"},
+		{New(-1500, "CLP"), "-1500"},
+		{New(1, "KWD"), "0.001"},
+		{New(12345, "KWD"), "12.345"},
+		{New(math.MaxInt64, "ARS"), "92233720368547758.07"},
+		{New(math.MinInt64, "ARS"), "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestStringParseRoundTrip(t *testing.T) {
+	for _, amount := range []int64{0, 1, -1, 99, 100, 123456, -98765, math.MaxInt64, -math.MaxInt64} {
+		for _, currency := range []string{"ARS", "CLP", "KWD"} {
+			m := New(amount, currency)
+			got, err := Parse(m.String(), currency)
+			if err != nil || got != m {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", m.String(), got, err, m)
+			}
+		}
+	}
+}
+
+func TestMinor(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(123456, "ARS"), "123456"},
+		{New(0, "ARS"), "0"},
+		{New(-50, "ARS"), "-50"},
+		{New(1500, "CLP"), "1500"},
+		{New(math.MaxInt64, "ARS"), "9223372036854775807"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.Minor(); got != tt.want {
+			t.Errorf("%+v.Minor() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Money
+		want Money
+		err  error
+	}{
+		{"positive", New(150, "ARS"), New(250, "ARS"), New(400, "ARS"), nil},
+		{"negative", New(150, "ARS"), New(-250, "ARS"), New(-100, "ARS"), nil},
+		{"zero", New(0, "ARS"), New(0, "ARS"), New(0, "ARS"), nil},
+		{"max", New(math.MaxInt64-1, "ARS"), New(1, "ARS"), New(math.MaxInt64, "ARS"), nil},
+		{"overflow", New(math.MaxInt64, "ARS"), New(1, "ARS"), Money{}, ErrOverflow},
+		{"underflow", New(math.MinInt64, "ARS"), New(-1, "ARS"), Money{}, ErrOverflow},
+		{"currency mismatch", New(1, "ARS"), New(1, "USD"), Money{}, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Add(tt.b)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Add() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("Add() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMul(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Money
+		n    int64
+		want Money
+		err  error
+	}{
+		{"by zero", New(1999, "ARS"), 0, New(0, "ARS"), nil},
+		{"zero amount", New(0, "ARS"), math.MaxInt64, New(0, "ARS"), nil},
+		{"by one", New(1999, "ARS"), 1, New(1999, "ARS"), nil},
+		{"quantity", New(1999, "ARS"), 3, New(5997, "ARS"), nil},
+		{"negative", New(1999, "ARS"), -2, New(-3998, "ARS"), nil},
+		{"min by one", New(math.MinInt64, "ARS"), 1, New(math.MinInt64, "ARS"), nil},
+		{"overflow", New(math.MaxInt64/2+1, "ARS"), 2, Money{}, ErrOverflow},
+		{"negative overflow", New(math.MaxInt64, "ARS"), -2, Money{}, ErrOverflow},
+		{"min by minus one", New(math.MinInt64, "ARS"), -1, Money{}, ErrOverflow},
+		{"minus one by min", New(-1, "ARS"), math.MinInt64, Money{}, ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.Mul(tt.n)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Mul(%d) error = %v, want %v", tt.n, err, tt.err)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("Mul(%d) = %+v, want %+v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
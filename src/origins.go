@@ -0,0 +1,187 @@
+package main
+
+import (
+	"log"
+	"errors"
+	"strconv"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+func validOrigin(origin *database.LocationOrigin) error {
+	if origin.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if origin.Branch != nil && *origin.Branch != "" {
+		return nil
+	}
+
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"street", origin.Street},
+		{"number", origin.Number},
+		{"zip", origin.Zip},
+		{"city", origin.City},
+	}
+	for _, field := range fields {
+		if originValue(field.value) == "" {
+			return errors.New(field.name + " is required when no branch is set")
+		}
+	}
+	return nil
+}
+
+func originValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// andreaniOrigin prefers the drop-off branch over the postal address.
+func andreaniOrigin(origin *database.LocationOrigin) andreani.Origin {
+	if branch := originValue(origin.Branch); branch != "" {
+		return andreani.Origin{Sucursal: &andreani.Sucursal{ID: branch}}
+	}
+
+	return andreani.Origin{
+		Postal: &andreani.Postal{
+			CodigoPostal: postalCode(originValue(origin.Zip)),
+			Calle:        originValue(origin.Street),
+			Numero:       originValue(origin.Number),
+			Localidad:    originValue(origin.City),
+			Region:       originValue(origin.Province),
+		},
+	}
+}
+
+// rateOrigin finds the configured location of the carrier callback origin,
+// Shopify only sends the address so it is matched by postal code.
+func (app *Application) rateOrigin(shop string, zip string) (*database.LocationOrigin, error) {
+	origins, err := app.db.GetLocationOrigins(shop)
+	if err != nil {
+		return nil, err
+	}
+
+	zip = postalCode(zip)
+	if zip == "" {
+		return nil, nil
+	}
+
+	for i := range origins {
+		if postalCode(originValue(origins[i].Zip)) == zip {
+			return &origins[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// orderLocationID returns the legacy id of the location shopify assigned to
+// the open fulfillment orders of the order, the first one when they are split
+// between locations. Zero when there is no open fulfillment order.
+func (app *Application) orderLocationID(token string, order *database.Order) (int64, error) {
+	fulfillments, err := app.shopApi.GetFulfillments(app.shop, token, order.OrderApiID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, node := range fulfillments.Nodes {
+		if id := node.AssignedLocation.LegacyID(); id != 0 {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+// shipmentOrigin returns the Andreani origin of the location fulfilling an
+// order, falling back to the shop origin branch.
+func (app *Application) shipmentOrigin(shop string, locationID int64, settings *database.CarrierSettings) (*andreani.Origin, error) {
+	origin, err := app.db.GetLocationOrigin(shop, locationID)
+	if err == nil {
+		result := andreaniOrigin(origin)
+		return &result, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if settings.OriginBranch == "" {
+		return nil, errors.New("location has no andreani origin")
+	}
+
+	return &andreani.Origin{Sucursal: &andreani.Sucursal{ID: settings.OriginBranch}}, nil
+}
+
+func (app *Application) GetLocationOriginsHandler(w http.ResponseWriter, r *http.Request) {
+	origins, err := app.db.GetLocationOrigins(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(origins); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutLocationOriginHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("locationID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var origin database.LocationOrigin
+	if err := json.NewDecoder(r.Body).Decode(&origin); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validOrigin(&origin); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	origin.Shop = app.shop
+	origin.LocationID = id
+
+	if err := app.db.UpsertLocationOrigin(&origin); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(origin); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) DeleteLocationOriginHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("locationID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.db.DeleteLocationOrigin(app.shop, id); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
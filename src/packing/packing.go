@@ -0,0 +1,246 @@
+package packing
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Box struct {
+	ID        int64
+	Name      string
+	Width     float64
+	Height    float64
+	Length    float64
+	MaxKilos  float64
+	TareKilos float64
+}
+
+func (b *Box) Volume() float64 {
+	return b.Width * b.Height * b.Length
+}
+
+type Item struct {
+	ProductID int64
+	VariantID int64
+	Width     float64
+	Height    float64
+	Length    float64
+	Kilos     float64
+}
+
+func (it *Item) Volume() float64 {
+	return it.Width * it.Height * it.Length
+}
+
+// Parcel is one package handed to the carrier, Box is nil when the item
+// did not fit in any box and ships in its own packaging.
+type Parcel struct {
+	Box    *Box
+	Items  []Item
+	Width  float64
+	Height float64
+	Length float64
+	Kilos  float64
+}
+
+func (p *Parcel) Volume() float64 {
+	return p.Width * p.Height * p.Length
+}
+
+// Limits are the carrier maximums for a single parcel, zero means no limit.
+type Limits struct {
+	MaxSide  float64
+	MaxKilos float64
+}
+
+// OversizedError is returned for an item that fits no box and is over the
+// parcel limits on its own.
+type OversizedError struct {
+	Item   Item
+	Reason string
+}
+
+func (e *OversizedError) Error() string {
+	return fmt.Sprintf("product %d can't ship as one parcel: %s", e.Item.ProductID, e.Reason)
+}
+
+// Check returns an *OversizedError when the item alone is over the limits.
+func (l Limits) Check(it Item) error {
+	longest := max(it.Width, it.Height, it.Length)
+	if l.MaxSide > 0 && longest > l.MaxSide {
+		return &OversizedError{Item: it, Reason: fmt.Sprintf("side of %.1f cm is over %.1f cm", longest, l.MaxSide)}
+	}
+	if l.MaxKilos > 0 && it.Kilos > l.MaxKilos {
+		return &OversizedError{Item: it, Reason: fmt.Sprintf("%.3f kg is over %.3f kg", it.Kilos, l.MaxKilos)}
+	}
+	return nil
+}
+
+type space struct {
+	w, h, l float64
+}
+
+type openBox struct {
+	box      *Box
+	spaces   []space
+	items    []Item
+	kilos    float64
+	maxKilos float64
+}
+
+func rotations(it Item) [6]space {
+	return [6]space{
+		{it.Width, it.Height, it.Length},
+		{it.Width, it.Length, it.Height},
+		{it.Height, it.Width, it.Length},
+		{it.Height, it.Length, it.Width},
+		{it.Length, it.Width, it.Height},
+		{it.Length, it.Height, it.Width},
+	}
+}
+
+// newOpenBox caps the box weight with the parcel limit, boxes without a
+// maximum take the limit.
+func newOpenBox(box *Box, limits Limits) *openBox {
+	maxKilos := box.MaxKilos
+	if limits.MaxKilos > 0 && (maxKilos <= 0 || limits.MaxKilos < maxKilos) {
+		maxKilos = limits.MaxKilos
+	}
+	return &openBox{
+		box:      box,
+		spaces:   []space{{box.Width, box.Height, box.Length}},
+		kilos:    box.TareKilos,
+		maxKilos: maxKilos,
+	}
+}
+
+// place puts the item in the first free space where it fits and splits the
+// rest of that space in three (guillotine cut).
+func (o *openBox) place(it Item) bool {
+	if o.maxKilos > 0 && o.kilos+it.Kilos > o.maxKilos {
+		return false
+	}
+
+	for i, free := range o.spaces {
+		for _, r := range rotations(it) {
+			if r.w > free.w || r.h > free.h || r.l > free.l {
+				continue
+			}
+
+			o.spaces = append(o.spaces[:i], o.spaces[i+1:]...)
+			o.spaces = append(
+				o.spaces,
+				space{free.w - r.w, free.h, free.l},
+				space{r.w, free.h - r.h, free.l},
+				space{r.w, r.h, free.l - r.l},
+			)
+			sort.Slice(o.spaces, func(a, b int) bool {
+				return o.spaces[a].w*o.spaces[a].h*o.spaces[a].l < o.spaces[b].w*o.spaces[b].h*o.spaces[b].l
+			})
+
+			o.items = append(o.items, it)
+			o.kilos += it.Kilos
+			return true
+		}
+	}
+	return false
+}
+
+func fits(box *Box, items []Item, limits Limits) bool {
+	o := newOpenBox(box, limits)
+	for _, it := range items {
+		if !o.place(it) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortByVolume(items []Item) {
+	sort.SliceStable(items, func(a, b int) bool {
+		return items[a].Volume() > items[b].Volume()
+	})
+}
+
+// Pack assigns the items to boxes using a first fit decreasing heuristic.
+// New boxes are opened as large as possible and are shrunk to the smallest
+// box that still holds their items once everything was placed. Items that
+// fit no box ship on their own unless they are over the limits.
+func Pack(items []Item, boxes []Box, limits Limits) ([]Parcel, error) {
+	sorted := append([]Item{}, items...)
+	sortByVolume(sorted)
+
+	catalog := append([]Box{}, boxes...)
+	sort.Slice(catalog, func(a, b int) bool {
+		return catalog[a].Volume() < catalog[b].Volume()
+	})
+
+	open := []*openBox{}
+	parcels := []Parcel{}
+
+	for _, it := range sorted {
+		placed := false
+		for _, o := range open {
+			if o.place(it) {
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+
+		for i := len(catalog) - 1; i >= 0; i-- {
+			o := newOpenBox(&catalog[i], limits)
+			if o.place(it) {
+				open = append(open, o)
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+
+		if err := limits.Check(it); err != nil {
+			return nil, err
+		}
+
+		parcels = append(parcels, Parcel{
+			Items:  []Item{it},
+			Width:  it.Width,
+			Height: it.Height,
+			Length: it.Length,
+			Kilos:  it.Kilos,
+		})
+	}
+
+	for _, o := range open {
+		box := o.box
+		for i := range catalog {
+			if catalog[i].Volume() >= box.Volume() {
+				break
+			}
+			if fits(&catalog[i], o.items, limits) {
+				box = &catalog[i]
+				break
+			}
+		}
+
+		kilos := box.TareKilos
+		for _, it := range o.items {
+			kilos += it.Kilos
+		}
+
+		parcels = append(parcels, Parcel{
+			Box:    box,
+			Items:  o.items,
+			Width:  box.Width,
+			Height: box.Height,
+			Length: box.Length,
+			Kilos:  kilos,
+		})
+	}
+
+	return parcels, nil
+}
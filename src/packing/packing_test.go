@@ -0,0 +1,177 @@
+package packing
+
+import (
+	"errors"
+	"testing"
+)
+
+func item(id int64, w, h, l, kilos float64) Item {
+	return Item{ProductID: id, Width: w, Height: h, Length: l, Kilos: kilos}
+}
+
+func TestPack(t *testing.T) {
+	small := Box{ID: 1, Name: "small", Width: 10, Height: 10, Length: 10, TareKilos: 0.1}
+	large := Box{ID: 2, Name: "large", Width: 40, Height: 40, Length: 40, TareKilos: 0.5}
+	long := Box{ID: 3, Name: "long", Width: 10, Height: 20, Length: 50}
+	cube := Box{ID: 4, Name: "cube", Width: 20, Height: 20, Length: 20}
+	light := Box{ID: 5, Name: "light", Width: 40, Height: 40, Length: 40, MaxKilos: 5}
+
+	// wantBoxes has the box id of every parcel in order, 0 for an item
+	// shipped on its own.
+	tests := []struct {
+		name      string
+		items     []Item
+		boxes     []Box
+		limits    Limits
+		wantBoxes []int64
+		wantKilos []float64
+	}{
+		{
+			name:      "single item",
+			items:     []Item{item(1, 8, 8, 8, 1)},
+			boxes:     []Box{small},
+			wantBoxes: []int64{1},
+			wantKilos: []float64{1.1},
+		},
+		{
+			name:      "rotation needed",
+			items:     []Item{item(1, 50, 10, 20, 2)},
+			boxes:     []Box{long},
+			wantBoxes: []int64{3},
+			wantKilos: []float64{2},
+		},
+		{
+			name:      "overflow into a second box",
+			items:     []Item{item(1, 20, 20, 15, 1), item(2, 20, 20, 15, 1)},
+			boxes:     []Box{cube},
+			wantBoxes: []int64{4, 4},
+			wantKilos: []float64{1, 1},
+		},
+		{
+			name:      "shrinks to a smaller box",
+			items:     []Item{item(1, 8, 8, 8, 1)},
+			boxes:     []Box{large, small},
+			wantBoxes: []int64{1},
+			wantKilos: []float64{1.1},
+		},
+		{
+			name:      "stays in the large box when the small one is too small",
+			items:     []Item{item(1, 8, 8, 8, 1), item(2, 8, 8, 8, 1)},
+			boxes:     []Box{small, large},
+			wantBoxes: []int64{2},
+			wantKilos: []float64{2.5},
+		},
+		{
+			name:      "box max kilos",
+			items:     []Item{item(1, 5, 5, 5, 3), item(2, 5, 5, 5, 3)},
+			boxes:     []Box{light},
+			wantBoxes: []int64{5, 5},
+			wantKilos: []float64{3, 3},
+		},
+		{
+			name:      "parcel limit caps boxes without max kilos",
+			items:     []Item{item(1, 5, 5, 5, 3), item(2, 5, 5, 5, 3)},
+			boxes:     []Box{large},
+			limits:    Limits{MaxKilos: 5},
+			wantBoxes: []int64{2, 2},
+			wantKilos: []float64{3.5, 3.5},
+		},
+		{
+			name:      "fits no box ships on its own",
+			items:     []Item{item(1, 60, 30, 30, 4)},
+			boxes:     []Box{small, large},
+			limits:    Limits{MaxSide: 100, MaxKilos: 50},
+			wantBoxes: []int64{0},
+			wantKilos: []float64{4},
+		},
+		{
+			name:      "no boxes",
+			items:     []Item{item(1, 10, 10, 10, 1), item(2, 10, 10, 10, 1)},
+			wantBoxes: []int64{0, 0},
+			wantKilos: []float64{1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parcels, err := Pack(tt.items, tt.boxes, tt.limits)
+			if err != nil {
+				t.Fatalf("Pack() error = %v", err)
+			}
+			if len(parcels) != len(tt.wantBoxes) {
+				t.Fatalf("Pack() = %d parcels, want %d", len(parcels), len(tt.wantBoxes))
+			}
+
+			packed := 0
+			for i, parcel := range parcels {
+				var id int64
+				if parcel.Box != nil {
+					id = parcel.Box.ID
+				}
+				if id != tt.wantBoxes[i] {
+					t.Errorf("parcel %d box = %d, want %d", i, id, tt.wantBoxes[i])
+				}
+				if diff := parcel.Kilos - tt.wantKilos[i]; diff > 1e-9 || diff < -1e-9 {
+					t.Errorf("parcel %d kilos = %v, want %v", i, parcel.Kilos, tt.wantKilos[i])
+				}
+				packed += len(parcel.Items)
+			}
+			if packed != len(tt.items) {
+				t.Errorf("Pack() packed %d items, want %d", packed, len(tt.items))
+			}
+		})
+	}
+}
+
+func TestPackOversized(t *testing.T) {
+	boxes := []Box{{ID: 1, Width: 30, Height: 30, Length: 30}}
+
+	tests := []struct {
+		name   string
+		item   Item
+		limits Limits
+	}{
+		{"side over the limit", item(1, 200, 20, 20, 5), Limits{MaxSide: 150, MaxKilos: 50}},
+		{"weight over the limit", item(1, 40, 40, 40, 80), Limits{MaxSide: 150, MaxKilos: 50}},
+		{"fits the box but not its weight", item(1, 10, 10, 10, 60), Limits{MaxKilos: 50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Pack([]Item{tt.item}, boxes, tt.limits)
+			var oversized *OversizedError
+			if !errors.As(err, &oversized) {
+				t.Fatalf("Pack() error = %v, want *OversizedError", err)
+			}
+			if oversized.Item.ProductID != tt.item.ProductID {
+				t.Errorf("OversizedError item = %d, want %d", oversized.Item.ProductID, tt.item.ProductID)
+			}
+		})
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		item   Item
+		limits Limits
+		ok     bool
+	}{
+		{"no limits", item(1, 500, 500, 500, 500), Limits{}, true},
+		{"within limits", item(1, 150, 10, 10, 50), Limits{MaxSide: 150, MaxKilos: 50}, true},
+		{"any side counts", item(1, 10, 10, 151, 1), Limits{MaxSide: 150}, false},
+		{"too heavy", item(1, 10, 10, 10, 50.5), Limits{MaxKilos: 50}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Check(tt.item)
+			if tt.ok && err != nil {
+				t.Errorf("Check() error = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("Check() error = nil, want *OversizedError")
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package pdf
+
+import (
+	"fmt"
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+var ErrEncrypted = errors.New("encrypted pdf files are not supported")
+
+var objectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// Document is a parsed PDF. Objects are found by scanning the file instead
+// of trusting the xref table, which also copes with broken offsets and
+// incremental updates.
+type Document struct {
+	objects map[int]Object
+	root    Ref
+}
+
+// Parse reads every object of the file, including the ones packed in
+// object streams.
+func Parse(data []byte) (*Document, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return nil, errors.New("not a pdf file")
+	}
+
+	doc := &Document{objects: map[int]Object{}}
+	var trailers []Dict
+
+	p := &parser{data: data}
+	for p.pos < len(data) {
+		loc := objectHeader.FindIndex(data[p.pos:])
+		if loc == nil {
+			break
+		}
+		start := p.pos + loc[0]
+
+		p.pos = start
+		num, obj, err := p.readIndirect()
+		if err != nil {
+			p.pos = start + 1
+			continue
+		}
+
+		// Later definitions come from incremental updates and win.
+		doc.objects[num] = obj
+
+		if stream, ok := obj.(*Stream); ok && stream.Dict.Name("Type") == "XRef" {
+			trailers = append(trailers, stream.Dict)
+		}
+	}
+
+	trailers = append(trailers, findTrailers(data)...)
+
+	for _, trailer := range trailers {
+		if _, ok := trailer["Encrypt"]; ok {
+			return nil, ErrEncrypted
+		}
+		if root, ok := trailer["Root"].(Ref); ok {
+			doc.root = root
+		}
+	}
+
+	if err := doc.expandObjectStreams(); err != nil {
+		return nil, err
+	}
+
+	if doc.root.Num == 0 {
+		if err := doc.findRoot(); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func findTrailers(data []byte) []Dict {
+	trailers := []Dict{}
+	offset := 0
+	for {
+		i := bytes.Index(data[offset:], []byte("trailer"))
+		if i < 0 {
+			return trailers
+		}
+		p := &parser{data: data, pos: offset + i + len("trailer")}
+		obj, err := p.readObject()
+		if dict, ok := obj.(Dict); err == nil && ok {
+			trailers = append(trailers, dict)
+		}
+		offset += i + len("trailer")
+	}
+}
+
+// expandObjectStreams adds the objects compressed in object streams, objects
+// stored directly in the file take precedence.
+func (doc *Document) expandObjectStreams() error {
+	packed := map[int]Object{}
+
+	for _, obj := range doc.objects {
+		stream, ok := obj.(*Stream)
+		if !ok || stream.Dict.Name("Type") != "ObjStm" {
+			continue
+		}
+
+		n, _ := stream.Dict["N"].(int64)
+		first, _ := stream.Dict["First"].(int64)
+		if n < 0 || first < 0 {
+			return errors.New("invalid object stream header")
+		}
+
+		data, err := stream.Decode()
+		if err != nil {
+			return err
+		}
+
+		header := &parser{data: data}
+		for i := int64(0); i < n; i++ {
+			num, err := header.readToken()
+			if err != nil {
+				return err
+			}
+			offset, err := header.readToken()
+			if err != nil {
+				return err
+			}
+			objNum, ok1 := num.(int64)
+			objOffset, ok2 := offset.(int64)
+			if !ok1 || !ok2 || objOffset < 0 || first > int64(len(data)) || objOffset >= int64(len(data))-first {
+				return errors.New("invalid object stream header")
+			}
+
+			p := &parser{data: data, pos: int(first + objOffset)}
+			obj, err := p.readObject()
+			if err != nil {
+				return err
+			}
+			packed[int(objNum)] = obj
+		}
+	}
+
+	for num, obj := range packed {
+		if _, ok := doc.objects[num]; !ok {
+			doc.objects[num] = obj
+		}
+	}
+	return nil
+}
+
+// findRoot looks for the catalog when no trailer points to it.
+func (doc *Document) findRoot() error {
+	for num, obj := range doc.objects {
+		if dict, ok := obj.(Dict); ok && dict.Name("Type") == "Catalog" {
+			doc.root = Ref{Num: num}
+			return nil
+		}
+	}
+	return errors.New("pdf catalog not found")
+}
+
+// Resolve follows references until it reaches a direct object.
+func (doc *Document) Resolve(obj Object) Object {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(Ref)
+		if !ok {
+			return obj
+		}
+		obj = doc.objects[ref.Num]
+	}
+	return nil
+}
+
+// Page is a page of the document with the inherited attributes already
+// applied to Dict.
+type Page struct {
+	Ref  Ref
+	Dict Dict
+}
+
+var inheritable = []Name{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+func (doc *Document) Pages() ([]Page, error) {
+	catalog, ok := doc.Resolve(doc.root).(Dict)
+	if !ok {
+		return nil, errors.New("invalid pdf catalog")
+	}
+
+	pages := []Page{}
+	visited := map[Ref]bool{}
+
+	var walk func(ref Ref, inherited Dict) error
+	walk = func(ref Ref, inherited Dict) error {
+		if visited[ref] {
+			return fmt.Errorf("page tree loop at object %d", ref.Num)
+		}
+		visited[ref] = true
+
+		node, ok := doc.Resolve(ref).(Dict)
+		if !ok {
+			return fmt.Errorf("invalid page tree node %d", ref.Num)
+		}
+
+		attrs := Dict{}
+		for _, key := range inheritable {
+			if value, ok := node[key]; ok {
+				attrs[key] = value
+			} else if value, ok := inherited[key]; ok {
+				attrs[key] = value
+			}
+		}
+
+		kids, isTree := doc.Resolve(node["Kids"]).(Array)
+		if node.Name("Type") == "Pages" || (isTree && node.Name("Type") != "Page") {
+			for _, kid := range kids {
+				kidRef, ok := kid.(Ref)
+				if !ok {
+					continue
+				}
+				if err := walk(kidRef, attrs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		page := Dict{}
+		for key, value := range node {
+			page[key] = value
+		}
+		for key, value := range attrs {
+			page[key] = value
+		}
+		pages = append(pages, Page{Ref: ref, Dict: page})
+		return nil
+	}
+
+	pagesRef, ok := catalog["Pages"].(Ref)
+	if !ok {
+		return nil, errors.New("pdf catalog without pages")
+	}
+
+	if err := walk(pagesRef, Dict{}); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// Box returns the visible area of the page, the crop box when it has one.
+func (doc *Document) Box(page *Page) ([4]float64, error) {
+	box := [4]float64{0, 0, 612, 792}
+
+	raw, ok := doc.Resolve(page.Dict["CropBox"]).(Array)
+	if !ok {
+		raw, ok = doc.Resolve(page.Dict["MediaBox"]).(Array)
+	}
+	if !ok {
+		return box, nil
+	}
+
+	if len(raw) != 4 {
+		return box, errors.New("invalid page box")
+	}
+	for i, value := range raw {
+		v, ok := number(doc.Resolve(value))
+		if !ok {
+			return box, errors.New("invalid page box")
+		}
+		box[i] = v
+	}
+
+	if box[0] > box[2] {
+		box[0], box[2] = box[2], box[0]
+	}
+	if box[1] > box[3] {
+		box[1], box[3] = box[3], box[1]
+	}
+	return box, nil
+}
+
+// Rotation returns the page rotation normalized to 0, 90, 180 or 270.
+func (doc *Document) Rotation(page *Page) int {
+	rotate, _ := doc.Resolve(page.Dict["Rotate"]).(int64)
+	r := int(rotate) % 360
+	if r < 0 {
+		r += 360
+	}
+	return r - r%90
+}
+
+// Content returns the decoded content of the page, joining its content
+// streams when it has many.
+func (doc *Document) Content(page *Page) ([]byte, error) {
+	var streams []*Stream
+
+	switch contents := doc.Resolve(page.Dict["Contents"]).(type) {
+	case *Stream:
+		streams = append(streams, contents)
+	case Array:
+		for _, item := range contents {
+			if stream, ok := doc.Resolve(item).(*Stream); ok {
+				streams = append(streams, stream)
+			}
+		}
+	}
+
+	var content bytes.Buffer
+	for _, stream := range streams {
+		data, err := stream.Decode()
+		if err != nil {
+			return nil, err
+		}
+		content.Write(data)
+		content.WriteByte('\n')
+	}
+	return content.Bytes(), nil
+}
@@ -0,0 +1,257 @@
+package pdf
+
+import (
+	"fmt"
+	"bytes"
+	"testing"
+
+	"compress/zlib"
+)
+
+// buildPDF writes a classic xref file whose objects are numbered from 1 in
+// the given order, root is the number of the catalog.
+func buildPDF(objects []string, root int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, root, xref)
+	return buf.Bytes()
+}
+
+func flate(t testing.TB, data string) string {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	return buf.String()
+}
+
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// labelPDF is a label like the Andreani ones, pages pages of 10x15 cm with
+// a flate compressed content stream and inherited resources.
+func labelPDF(t testing.TB, pages int) []byte {
+	content := flate(t, "BT /F1 12 Tf 20 380 Td (Andreani) Tj ET 10 10 263 405 re S")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // page tree, filled below
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		stream("/Filter /FlateDecode", content),
+	}
+
+	kids := ""
+	for i := 0; i < pages; i++ {
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>")
+		kids += fmt.Sprintf("%d 0 R ", len(objects))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 283.46 425.2] /Resources << /Font << /F1 3 0 R >> >> >>", kids, pages)
+
+	return buildPDF(objects, 1)
+}
+
+// objectStreamPDF keeps the catalog and the page tree inside an object
+// stream and points to the catalog from an xref stream, like PDF 1.5 files.
+func objectStreamPDF(t testing.TB) []byte {
+	packed := "<< /Type /Catalog /Pages 2 0 R >> << /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	header := fmt.Sprintf("1 0 2 %d ", len("<< /Type /Catalog /Pages 2 0 R >> "))
+	data := flate(t, header+packed)
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 99 0 R >>", // replaced by the direct object 1 below
+		"",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Rotate 90 /Contents 4 0 R >>",
+		stream("", "0 0 100 100 re f"),
+		stream(fmt.Sprintf("/Type /ObjStm /N 2 /First %d /Filter /FlateDecode", len(header)), data),
+		stream("/Type /XRef /Size 7 /Root 1 0 R /W [1 2 1]", "\x00\x00\x00\x00"),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+	for i, obj := range objects {
+		// Objects 1 and 2 only live in the object stream.
+		if i < 2 {
+			continue
+		}
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	buf.WriteString("startxref\n0\n%%EOF\n")
+	return buf.Bytes()
+}
+
+func pageCount(t *testing.T, data []byte) int {
+	t.Helper()
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	pages, err := doc.Pages()
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+	return len(pages)
+}
+
+func TestParseLabel(t *testing.T) {
+	doc, err := Parse(labelPDF(t, 3))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	pages, err := doc.Pages()
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want 3", len(pages))
+	}
+
+	box, err := doc.Box(&pages[0])
+	if err != nil {
+		t.Fatalf("Box: %v", err)
+	}
+	if box != [4]float64{0, 0, 283.46, 425.2} {
+		t.Fatalf("inherited MediaBox = %v", box)
+	}
+
+	if _, ok := doc.Resolve(pages[0].Dict["Resources"]).(Dict); !ok {
+		t.Fatalf("page didn't inherit Resources: %v", pages[0].Dict)
+	}
+
+	content, err := doc.Content(&pages[0])
+	if err != nil {
+		t.Fatalf("Content: %v", err)
+	}
+	if !bytes.Contains(content, []byte("(Andreani) Tj")) {
+		t.Fatalf("content not decoded: %q", content)
+	}
+}
+
+func TestParseObjectStream(t *testing.T) {
+	doc, err := Parse(objectStreamPDF(t))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	pages, err := doc.Pages()
+	if err != nil {
+		t.Fatalf("Pages: %v", err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	if r := doc.Rotation(&pages[0]); r != 90 {
+		t.Fatalf("Rotation = %d, want 90", r)
+	}
+}
+
+// badObjectStream builds a file whose only catalog is packed in an object
+// stream with the given header and dictionary entries.
+func badObjectStream(t testing.TB, header, dict string) []byte {
+	data := flate(t, header+"<< /Type /Catalog >>")
+	objects := []string{
+		stream("/Type /ObjStm /Filter /FlateDecode "+dict, data),
+	}
+	return buildPDF(objects, 9)
+}
+
+func TestParseInvalid(t *testing.T) {
+	label := labelPDF(t, 1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"garbage", []byte("this is not a pdf at all")},
+		{"header only", []byte("%PDF-1.4\n")},
+		{"binary", bytes.Repeat([]byte{0xff, 0x00, 0x7f}, 100)},
+		{"truncated header", label[:12]},
+		{"truncated object", label[:40]},
+		{"unterminated dict", []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R\n")},
+		{"unterminated array", []byte("%PDF-1.4\n1 0 obj\n[1 2 3\n")},
+		{"unterminated string", []byte("%PDF-1.4\n1 0 obj\n(abc\\\n")},
+		{"stream without end", []byte("%PDF-1.4\n1 0 obj\n<< /Length 5 >>\nstream\nabc")},
+		{"huge length", []byte("%PDF-1.4\n1 0 obj\n<< /Length 9223372036854775807 >>\nstream\nabc\nendstream\nendobj\n")},
+		{"negative length", []byte("%PDF-1.4\n1 0 obj\n<< /Length -5 >>\nstream\nabc\nendstream\nendobj\n")},
+		{"encrypted", bytes.Replace(labelPDF(t, 1), []byte("/Root 1 0 R"), []byte("/Root 1 0 R /Encrypt << /Filter /Standard >>"), 1)},
+		{"page tree loop", buildPDF([]string{
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [2 0 R] /Count 1 >>",
+		}, 1)},
+		{"negative object offset", badObjectStream(t, "1 -100 ", "/N 1 /First 8")},
+		{"offset past the end", badObjectStream(t, "1 100000 ", "/N 1 /First 9")},
+		{"negative first", badObjectStream(t, "1 0 ", "/N 1 /First -50")},
+		{"negative count", badObjectStream(t, "1 0 ", "/N -1 /First 4")},
+		{"huge first", badObjectStream(t, "1 0 ", "/N 1 /First 9223372036854775807")},
+		{"huge offset", badObjectStream(t, "1 9223372036854775807 ", "/N 1 /First 22")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.data)
+			if err != nil {
+				return
+			}
+			if _, err := doc.Pages(); err == nil {
+				t.Fatalf("invalid file parsed without errors")
+			}
+		})
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, data := range [][]byte{labelPDF(t, 2), objectStreamPDF(t)} {
+		for n := 0; n < len(data); n++ {
+			doc, err := Parse(data[:n])
+			if err != nil {
+				continue
+			}
+			// Truncated files may still parse, reading them must not panic.
+			if pages, err := doc.Pages(); err == nil {
+				for i := range pages {
+					doc.Box(&pages[i])
+					doc.Content(&pages[i])
+				}
+			}
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(labelPDF(f, 1))
+	f.Add(labelPDF(f, 3))
+	f.Add(objectStreamPDF(f))
+	f.Add(badObjectStream(f, "1 -100 ", "/N 1 /First 8"))
+	f.Add([]byte("%PDF-1.4\n1 0 obj\n<< /Length 9223372036854775807 >>\nstream\nabc\nendstream\nendobj\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := Parse(data)
+		if err != nil {
+			return
+		}
+		pages, err := doc.Pages()
+		if err != nil {
+			return
+		}
+		for i := range pages {
+			doc.Box(&pages[i])
+			doc.Rotation(&pages[i])
+			doc.Content(&pages[i])
+		}
+		Merge(&bytes.Buffer{}, [][]byte{data}, LayoutA4)
+	})
+}
@@ -0,0 +1,321 @@
+package pdf
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+)
+
+// Layout is how the source pages are placed on the merged pages.
+type Layout struct {
+	Name string
+	// Width and Height of the output pages in points, zero keeps every page
+	// as it is.
+	Width  float64
+	Height float64
+	Cols   int
+	Rows   int
+	Margin float64
+}
+
+const mm = 72 / 25.4
+
+var (
+	// LayoutMerge copies the pages unchanged one after the other.
+	LayoutMerge = Layout{Name: "merge"}
+	// LayoutA4 places four labels on each A4 page.
+	LayoutA4 = Layout{Name: "a4", Width: 210 * mm, Height: 297 * mm, Cols: 2, Rows: 2, Margin: 5 * mm}
+	// LayoutThermal places one label on each 10x15 cm page.
+	LayoutThermal = Layout{Name: "10x15", Width: 100 * mm, Height: 150 * mm, Cols: 1, Rows: 1}
+)
+
+var Layouts = map[string]Layout{
+	LayoutMerge.Name:   LayoutMerge,
+	LayoutA4.Name:      LayoutA4,
+	LayoutThermal.Name: LayoutThermal,
+}
+
+// copier copies objects of a source document into the writer, numbering
+// them again and copying every object only once.
+type copier struct {
+	doc  *Document
+	w    *Writer
+	refs map[Ref]Ref
+}
+
+func (c *copier) copy(obj Object) Object {
+	switch v := obj.(type) {
+	case Ref:
+		if ref, ok := c.refs[v]; ok {
+			return ref
+		}
+		target, ok := c.doc.objects[v.Num]
+		if !ok || target == nil {
+			return nil
+		}
+		ref := c.w.Reserve()
+		c.refs[v] = ref
+		c.w.Set(ref, c.copy(target))
+		return ref
+	case Array:
+		array := make(Array, len(v))
+		for i, item := range v {
+			array[i] = c.copy(item)
+		}
+		return array
+	case Dict:
+		return c.copyDict(v)
+	case *Stream:
+		dict := c.copyDict(v.Dict)
+		delete(dict, "Length")
+		return &Stream{Dict: dict, Data: v.Data}
+	}
+	return obj
+}
+
+func (c *copier) copyDict(v Dict) Dict {
+	dict := Dict{}
+	pageNode := v.Name("Type") == "Page" || v.Name("Type") == "Pages"
+	for key, value := range v {
+		// Pages only keep the parent set by the merger, following it would
+		// copy the whole source page tree.
+		if pageNode && key == "Parent" {
+			continue
+		}
+		dict[key] = c.copy(value)
+	}
+	return dict
+}
+
+type merger struct {
+	w      *Writer
+	pages  Ref
+	kids   Array
+	layout Layout
+
+	// pending forms of the output page being filled.
+	forms []placedForm
+}
+
+type placedForm struct {
+	ref    Ref
+	box    [4]float64
+	rotate int
+}
+
+// Merge joins the pages of every file using the layout and writes the
+// result to out.
+func Merge(out io.Writer, files [][]byte, layout Layout) error {
+	if len(files) == 0 {
+		return errors.New("no files to merge")
+	}
+
+	m := &merger{w: NewWriter(), layout: layout}
+	m.pages = m.w.Reserve()
+
+	for i, data := range files {
+		doc, err := Parse(data)
+		if err != nil {
+			return fmt.Errorf("file %d: %w", i, err)
+		}
+		if err := m.addDocument(doc); err != nil {
+			return fmt.Errorf("file %d: %w", i, err)
+		}
+	}
+
+	if err := m.flush(); err != nil {
+		return err
+	}
+
+	if len(m.kids) == 0 {
+		return errors.New("files have no pages")
+	}
+
+	m.w.Set(m.pages, Dict{
+		"Type":  Name("Pages"),
+		"Kids":  m.kids,
+		"Count": int64(len(m.kids)),
+	})
+	root := m.w.Add(Dict{"Type": Name("Catalog"), "Pages": m.pages})
+
+	_, err := m.w.WriteTo(out, root)
+	return err
+}
+
+func (m *merger) addDocument(doc *Document) error {
+	pages, err := doc.Pages()
+	if err != nil {
+		return err
+	}
+
+	c := &copier{doc: doc, w: m.w, refs: map[Ref]Ref{}}
+
+	for i := range pages {
+		page := &pages[i]
+		if m.layout.Width == 0 {
+			m.copyPage(c, page)
+			continue
+		}
+		if err := m.placePage(c, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *merger) copyPage(c *copier, page *Page) {
+	ref := m.w.Reserve()
+	c.refs[page.Ref] = ref
+
+	dict := c.copyDict(page.Dict)
+	dict["Parent"] = m.pages
+	m.w.Set(ref, dict)
+	m.kids = append(m.kids, ref)
+}
+
+// placePage turns the page into a form XObject and queues it for the next
+// free cell.
+func (m *merger) placePage(c *copier, page *Page) error {
+	box, err := c.doc.Box(page)
+	if err != nil {
+		return err
+	}
+
+	content, err := c.doc.Content(page)
+	if err != nil {
+		return err
+	}
+
+	resources := c.copy(page.Dict["Resources"])
+	if resources == nil {
+		resources = Dict{}
+	}
+
+	form, err := NewFlateStream(Dict{
+		"Type":      Name("XObject"),
+		"Subtype":   Name("Form"),
+		"BBox":      Array{box[0], box[1], box[2], box[3]},
+		"Resources": resources,
+	}, content)
+	if err != nil {
+		return err
+	}
+
+	return m.queue(placedForm{ref: m.w.Add(form), box: box, rotate: c.doc.Rotation(page)})
+}
+
+func (m *merger) queue(form placedForm) error {
+	m.forms = append(m.forms, form)
+	if len(m.forms) == m.layout.Cols*m.layout.Rows {
+		return m.flush()
+	}
+	return nil
+}
+
+// placement returns the matrix that draws a box rotated clockwise by rotate
+// degrees, scaled by s, with its visible lower left corner at (tx, ty).
+func placement(box [4]float64, rotate int, s, tx, ty float64) [6]float64 {
+	w := box[2] - box[0]
+	h := box[3] - box[1]
+
+	var r [6]float64
+	switch rotate {
+	case 90:
+		r = [6]float64{0, -1, 1, 0, 0, w}
+	case 180:
+		r = [6]float64{-1, 0, 0, -1, w, h}
+	case 270:
+		r = [6]float64{0, 1, -1, 0, h, 0}
+	default:
+		r = [6]float64{1, 0, 0, 1, 0, 0}
+	}
+
+	return [6]float64{
+		s * r[0],
+		s * r[1],
+		s * r[2],
+		s * r[3],
+		s*(r[4]-r[0]*box[0]-r[2]*box[1]) + tx,
+		s*(r[5]-r[1]*box[0]-r[3]*box[1]) + ty,
+	}
+}
+
+// flush writes the output page with the queued forms.
+func (m *merger) flush() error {
+	if len(m.forms) == 0 {
+		return nil
+	}
+
+	cellW := m.layout.Width / float64(m.layout.Cols)
+	cellH := m.layout.Height / float64(m.layout.Rows)
+	innerW := cellW - 2*m.layout.Margin
+	innerH := cellH - 2*m.layout.Margin
+
+	var content bytes.Buffer
+	xobjects := Dict{}
+
+	for i, form := range m.forms {
+		col := i % m.layout.Cols
+		row := i / m.layout.Cols
+
+		rotate := form.rotate
+		w := form.box[2] - form.box[0]
+		h := form.box[3] - form.box[1]
+		if rotate == 90 || rotate == 270 {
+			w, h = h, w
+		}
+
+		// Turn landscape labels to use portrait cells, and the other way,
+		// undoing the page rotation when it is what made it landscape.
+		if (w > h) != (innerW > innerH) && w != h {
+			if rotate == 90 {
+				rotate = 0
+			} else {
+				rotate = (rotate + 90) % 360
+			}
+			w, h = h, w
+		}
+
+		if w <= 0 || h <= 0 {
+			continue
+		}
+
+		s := innerW / w
+		if innerH/h < s {
+			s = innerH / h
+		}
+
+		x := float64(col)*cellW + m.layout.Margin + (innerW-w*s)/2
+		y := m.layout.Height - float64(row+1)*cellH + m.layout.Margin + (innerH-h*s)/2
+
+		matrix := placement(form.box, rotate, s, x, y)
+		name := Name(fmt.Sprintf("L%d", i))
+		xobjects[name] = form.ref
+
+		content.WriteString("q ")
+		for _, v := range matrix {
+			content.WriteString(formatNumber(v))
+			content.WriteByte(' ')
+		}
+		content.WriteString("cm ")
+		writeName(&content, name)
+		content.WriteString(" Do Q\n")
+	}
+	m.forms = nil
+
+	contents, err := NewFlateStream(Dict{}, content.Bytes())
+	if err != nil {
+		return err
+	}
+
+	page := m.w.Add(Dict{
+		"Type":      Name("Page"),
+		"Parent":    m.pages,
+		"MediaBox":  Array{int64(0), int64(0), m.layout.Width, m.layout.Height},
+		"Resources": Dict{"XObject": xobjects},
+		"Contents":  m.w.Add(contents),
+	})
+	m.kids = append(m.kids, page)
+	return nil
+}
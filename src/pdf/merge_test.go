@@ -0,0 +1,119 @@
+package pdf
+
+import (
+	"math"
+	"bytes"
+	"testing"
+)
+
+// The writer keeps four decimals.
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+func TestMergeLayouts(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		pages  int
+		want   int
+	}{
+		{LayoutMerge, 1, 1},
+		{LayoutMerge, 5, 5},
+		{LayoutA4, 1, 1},
+		{LayoutA4, 5, 2},
+		{LayoutThermal, 1, 1},
+		{LayoutThermal, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := Merge(&out, [][]byte{labelPDF(t, tt.pages)}, tt.layout); err != nil {
+				t.Fatalf("Merge: %v", err)
+			}
+
+			doc, err := Parse(out.Bytes())
+			if err != nil {
+				t.Fatalf("Parse merged: %v", err)
+			}
+			pages, err := doc.Pages()
+			if err != nil {
+				t.Fatalf("Pages: %v", err)
+			}
+			if len(pages) != tt.want {
+				t.Fatalf("got %d pages, want %d", len(pages), tt.want)
+			}
+
+			for i := range pages {
+				box, err := doc.Box(&pages[i])
+				if err != nil {
+					t.Fatalf("Box: %v", err)
+				}
+				if tt.layout.Width != 0 && (!near(box[2], tt.layout.Width) || !near(box[3], tt.layout.Height)) {
+					t.Fatalf("page %d box = %v, want %vx%v", i, box, tt.layout.Width, tt.layout.Height)
+				}
+				if _, err := doc.Content(&pages[i]); err != nil {
+					t.Fatalf("Content: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeManyFiles(t *testing.T) {
+	files := [][]byte{labelPDF(t, 1), objectStreamPDF(t), labelPDF(t, 2)}
+
+	for _, layout := range []Layout{LayoutMerge, LayoutA4, LayoutThermal} {
+		var out bytes.Buffer
+		if err := Merge(&out, files, layout); err != nil {
+			t.Fatalf("%s: Merge: %v", layout.Name, err)
+		}
+
+		want := 4
+		if layout.Name == LayoutA4.Name {
+			want = 1
+		}
+		if got := pageCount(t, out.Bytes()); got != want {
+			t.Fatalf("%s: got %d pages, want %d", layout.Name, got, want)
+		}
+
+		// The merged file is merged again the same way the batch endpoint
+		// would with a reprinted batch.
+		var again bytes.Buffer
+		if err := Merge(&again, [][]byte{out.Bytes()}, LayoutMerge); err != nil {
+			t.Fatalf("%s: Merge again: %v", layout.Name, err)
+		}
+		if got := pageCount(t, again.Bytes()); got != want {
+			t.Fatalf("%s: merged again %d pages, want %d", layout.Name, got, want)
+		}
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	if err := Merge(&bytes.Buffer{}, nil, LayoutA4); err == nil {
+		t.Fatalf("merging nothing should fail")
+	}
+	if err := Merge(&bytes.Buffer{}, [][]byte{[]byte("garbage")}, LayoutA4); err == nil {
+		t.Fatalf("merging garbage should fail")
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	box := [4]float64{0, 0, 100, 200}
+
+	tests := []struct {
+		rotate int
+		want   [6]float64
+	}{
+		{0, [6]float64{1, 0, 0, 1, 10, 20}},
+		{90, [6]float64{0, -1, 1, 0, 10, 120}},
+		{180, [6]float64{-1, 0, 0, -1, 110, 220}},
+		{270, [6]float64{0, 1, -1, 0, 210, 20}},
+	}
+
+	for _, tt := range tests {
+		if got := placement(box, tt.rotate, 1, 10, 20); got != tt.want {
+			t.Errorf("placement(%d) = %v, want %v", tt.rotate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pdf
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"errors"
+
+	"compress/zlib"
+)
+
+// Object is any PDF value: nil, bool, int64, float64, String, Name, Array,
+// Dict, Ref or *Stream.
+type Object interface{}
+
+type Name string
+
+type String string
+
+type Array []Object
+
+type Dict map[Name]Object
+
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// Stream keeps Data as it is stored in the file, still encoded.
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+var ErrUnsupportedFilter = errors.New("unsupported stream filter")
+
+func (d Dict) Name(key Name) Name {
+	name, _ := d[key].(Name)
+	return name
+}
+
+func number(o Object) (float64, bool) {
+	switch v := o.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func streamFilters(dict Dict) []Name {
+	switch filter := dict["Filter"].(type) {
+	case Name:
+		return []Name{filter}
+	case Array:
+		names := []Name{}
+		for _, f := range filter {
+			if name, ok := f.(Name); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// Decode returns the stream data without filters, only FlateDecode without
+// predictors is supported which is what labels and object streams use.
+func (s *Stream) Decode() ([]byte, error) {
+	data := s.Data
+	for _, filter := range streamFilters(s.Dict) {
+		switch filter {
+		case "FlateDecode", "Fl":
+			if params, ok := s.Dict["DecodeParms"].(Dict); ok {
+				if predictor, ok := params["Predictor"].(int64); ok && predictor > 1 {
+					return nil, fmt.Errorf("%w: predictor %d", ErrUnsupportedFilter, predictor)
+				}
+			}
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := io.ReadAll(r)
+			r.Close()
+			// Some producers truncate the checksum, keep what was inflated.
+			if err != nil && len(decoded) == 0 {
+				return nil, err
+			}
+			data = decoded
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedFilter, filter)
+		}
+	}
+	return data, nil
+}
+
+// NewFlateStream compresses data into a stream with the given dictionary.
+func NewFlateStream(dict Dict, data []byte) (*Stream, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if dict == nil {
+		dict = Dict{}
+	}
+	dict["Filter"] = Name("FlateDecode")
+	delete(dict, "DecodeParms")
+
+	return &Stream{Dict: dict, Data: buf.Bytes()}, nil
+}
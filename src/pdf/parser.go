@@ -0,0 +1,386 @@
+package pdf
+
+import (
+	"fmt"
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+var errDelimiter = errors.New("unexpected delimiter")
+
+// keyword is a bare word that is not a value, like obj, stream or R.
+type keyword string
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func isRegular(c byte) bool {
+	return !isSpace(c) && !isDelimiter(c)
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		c := p.data[p.pos]
+		if isSpace(c) {
+			p.pos++
+			continue
+		}
+		if c == '%' {
+			for !p.eof() && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+func (p *parser) readRegular() string {
+	start := p.pos
+	for !p.eof() && isRegular(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// readToken returns the next value or a delimiter keyword ("]", ">>").
+func (p *parser) readToken() (Object, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, errors.New("unexpected end of file")
+	}
+
+	c := p.data[p.pos]
+	switch {
+	case c == '/':
+		p.pos++
+		return p.readName(), nil
+	case c == '(':
+		p.pos++
+		return p.readLiteralString()
+	case c == '<':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '<' {
+			p.pos += 2
+			return p.readDict()
+		}
+		p.pos++
+		return p.readHexString()
+	case c == '>':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '>' {
+			p.pos += 2
+			return keyword(">>"), nil
+		}
+		return nil, fmt.Errorf("unexpected > at %d", p.pos)
+	case c == '[':
+		p.pos++
+		return p.readArray()
+	case c == ']':
+		p.pos++
+		return keyword("]"), nil
+	case c == '{' || c == '}' || c == ')':
+		return nil, fmt.Errorf("unexpected %c at %d", c, p.pos)
+	}
+
+	word := p.readRegular()
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	if (word[0] >= '0' && word[0] <= '9') || word[0] == '-' || word[0] == '+' || word[0] == '.' {
+		if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(word, 64); err == nil {
+			return f, nil
+		}
+	}
+
+	return keyword(word), nil
+}
+
+// readObject reads a value, resolving "num gen R" into a Ref.
+func (p *parser) readObject() (Object, error) {
+	obj, err := p.readToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := obj.(keyword); ok {
+		if k == "]" || k == ">>" {
+			return nil, errDelimiter
+		}
+		return obj, nil
+	}
+
+	num, ok := obj.(int64)
+	if !ok || num < 0 {
+		return obj, nil
+	}
+
+	save := p.pos
+	gen, err := p.readToken()
+	if g, ok := gen.(int64); err == nil && ok && g >= 0 {
+		r, err := p.readToken()
+		if k, ok := r.(keyword); err == nil && ok && k == "R" {
+			return Ref{Num: int(num), Gen: int(g)}, nil
+		}
+	}
+	p.pos = save
+	return obj, nil
+}
+
+func (p *parser) readName() Name {
+	var buf []byte
+	for !p.eof() && isRegular(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				buf = append(buf, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		buf = append(buf, c)
+		p.pos++
+	}
+	return Name(buf)
+}
+
+func (p *parser) readLiteralString() (Object, error) {
+	var buf []byte
+	depth := 1
+	for !p.eof() {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(buf), nil
+			}
+		case '\\':
+			if p.eof() {
+				return nil, errors.New("unterminated string")
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if !p.eof() && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && !p.eof() && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		buf = append(buf, c)
+	}
+	return nil, errors.New("unterminated string")
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func (p *parser) readHexString() (Object, error) {
+	var digits []byte
+	for !p.eof() {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, 0)
+			}
+			buf := make([]byte, len(digits)/2)
+			for i := range buf {
+				buf[i] = digits[2*i]<<4 | digits[2*i+1]
+			}
+			return String(buf), nil
+		}
+		if v, ok := unhex(c); ok {
+			digits = append(digits, v)
+		} else if !isSpace(c) {
+			return nil, fmt.Errorf("invalid hex string at %d", p.pos)
+		}
+	}
+	return nil, errors.New("unterminated hex string")
+}
+
+func (p *parser) readArray() (Object, error) {
+	array := Array{}
+	for {
+		obj, err := p.readObject()
+		if errors.Is(err, errDelimiter) {
+			return array, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, obj)
+	}
+}
+
+func (p *parser) readDict() (Object, error) {
+	dict := Dict{}
+	for {
+		key, err := p.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := key.(keyword); ok && k == ">>" {
+			return dict, nil
+		}
+		name, ok := key.(Name)
+		if !ok {
+			return nil, fmt.Errorf("dictionary key is not a name at %d", p.pos)
+		}
+
+		value, err := p.readObject()
+		if err != nil {
+			return nil, err
+		}
+		dict[name] = value
+	}
+}
+
+// readIndirect reads "num gen obj ... endobj" at the current position and
+// returns the object and its number.
+func (p *parser) readIndirect() (int, Object, error) {
+	num, err := p.readToken()
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := p.readToken(); err != nil {
+		return 0, nil, err
+	}
+	if k, err := p.readToken(); err != nil || k != keyword("obj") {
+		return 0, nil, fmt.Errorf("expected obj at %d", p.pos)
+	}
+
+	n, ok := num.(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid object number at %d", p.pos)
+	}
+
+	obj, err := p.readObject()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	save := p.pos
+	next, err := p.readToken()
+	if err == nil && next == keyword("stream") {
+		dict, ok := obj.(Dict)
+		if !ok {
+			return 0, nil, fmt.Errorf("stream without dictionary in object %d", n)
+		}
+		stream, err := p.readStreamData(dict)
+		if err != nil {
+			return 0, nil, err
+		}
+		obj = stream
+		save = p.pos
+		next, err = p.readToken()
+	}
+
+	// endobj is missing in some broken files, don't consume what follows.
+	if err != nil || next != keyword("endobj") {
+		p.pos = save
+	}
+
+	return int(n), obj, nil
+}
+
+func (p *parser) readStreamData(dict Dict) (*Stream, error) {
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	// Trust Length only when endstream is where it says.
+	if length, ok := dict["Length"].(int64); ok && length >= 0 && length <= int64(len(p.data)-start) {
+		end := start + int(length)
+		rest := bytes.TrimLeft(p.data[end:], "\r\n \t")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			p.pos = len(p.data) - len(rest) + len("endstream")
+			return &Stream{Dict: dict, Data: p.data[start:end]}, nil
+		}
+	}
+
+	i := bytes.Index(p.data[start:], []byte("endstream"))
+	if i < 0 {
+		return nil, errors.New("stream without endstream")
+	}
+	end := start + i
+	if end > start && p.data[end-1] == '\n' {
+		end--
+	}
+	if end > start && p.data[end-1] == '\r' {
+		end--
+	}
+	p.pos = start + i + len("endstream")
+	return &Stream{Dict: dict, Data: p.data[start:end]}, nil
+}
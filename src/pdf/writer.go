@@ -0,0 +1,174 @@
+package pdf
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bytes"
+	"errors"
+	"strings"
+	"strconv"
+
+	"encoding/hex"
+)
+
+// Writer builds a new PDF file, objects are numbered in the order they are
+// added or reserved.
+type Writer struct {
+	objects []Object
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Reserve returns a reference for an object that is set later, needed for
+// objects that point to each other.
+func (w *Writer) Reserve() Ref {
+	w.objects = append(w.objects, nil)
+	return Ref{Num: len(w.objects)}
+}
+
+func (w *Writer) Set(ref Ref, obj Object) {
+	w.objects[ref.Num-1] = obj
+}
+
+func (w *Writer) Add(obj Object) Ref {
+	ref := w.Reserve()
+	w.Set(ref, obj)
+	return ref
+}
+
+func writeName(buf *bytes.Buffer, name Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x21 || c > 0x7e || c == '#' || isDelimiter(c) {
+			fmt.Fprintf(buf, "#%02X", c)
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+}
+
+func formatNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 4, 64)
+	s = trimZeros(s)
+	if s == "-0" {
+		return "0"
+	}
+	return s
+}
+
+func trimZeros(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	for s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func writeObject(buf *bytes.Buffer, obj Object) error {
+	switch v := obj.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(formatNumber(v))
+	case Name:
+		writeName(buf, v)
+	case String:
+		buf.WriteByte('<')
+		buf.WriteString(hex.EncodeToString([]byte(v)))
+		buf.WriteByte('>')
+	case Ref:
+		fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+	case Array:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			if err := writeObject(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case Dict:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, string(key))
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("<<")
+		for _, key := range keys {
+			writeName(buf, Name(key))
+			buf.WriteByte(' ')
+			if err := writeObject(buf, v[Name(key)]); err != nil {
+				return err
+			}
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(">>")
+	case *Stream:
+		dict := Dict{}
+		for key, value := range v.Dict {
+			dict[key] = value
+		}
+		dict["Length"] = int64(len(v.Data))
+		if err := writeObject(buf, dict); err != nil {
+			return err
+		}
+		buf.WriteString("\nstream\n")
+		buf.Write(v.Data)
+		buf.WriteString("\nendstream")
+	default:
+		return fmt.Errorf("cannot write %T", obj)
+	}
+	return nil
+}
+
+// WriteTo writes the file with a classic xref table and root as catalog.
+func (w *Writer) WriteTo(out io.Writer, root Ref) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(w.objects))
+	for i, obj := range w.objects {
+		if obj == nil {
+			return 0, errors.New("pdf object reserved but never set")
+		}
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		if err := writeObject(&buf, obj); err != nil {
+			return 0, err
+		}
+		buf.WriteString("\nendobj\n")
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+
+	buf.WriteString("trailer\n")
+	if err := writeObject(&buf, Dict{"Size": int64(len(w.objects) + 1), "Root": root}); err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(&buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+
+	n, err := out.Write(buf.Bytes())
+	return int64(n), err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"os"
+	"log"
+	"time"
+	"errors"
+	"context"
+	"strings"
+
+	"database/sql"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+	"tomi/src/shopify"
+)
+
+const defaultTrackingInterval = time.Hour
+
+func trackingInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("TRACKING_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return defaultTrackingInterval
+	}
+	return interval
+}
+
+// ErrNoFulfillment is returned while the merchant hasn't fulfilled the order
+// in shopify with the shipping tracking number, the events wait until then.
+var ErrNoFulfillment = errors.New("no shopify fulfillment with the shipping tracking number")
+
+type shipmentState struct {
+	match    string
+	status   string
+	terminal bool
+}
+
+// shipmentStates maps the Andreani state names to shopify fulfillment event
+// statuses, the first match wins so "no entregado" goes before "entregado".
+var shipmentStates = []shipmentState{
+	{"no entregado", shopify.FulfillmentFailure, false},
+	{"entregado", shopify.FulfillmentDelivered, true},
+	{"cancelad", shopify.FulfillmentFailure, true},
+	{"anulad", shopify.FulfillmentFailure, true},
+	{"rechazad", shopify.FulfillmentFailure, true},
+	{"devuelt", shopify.FulfillmentFailure, true},
+	{"siniestr", shopify.FulfillmentFailure, true},
+	{"visita", shopify.FulfillmentAttemptedDelivery, false},
+	{"en distribucion", shopify.FulfillmentOutForDelivery, false},
+	{"para retirar", shopify.FulfillmentReadyForPickup, false},
+	{"en viaje", shopify.FulfillmentInTransit, false},
+	{"transito", shopify.FulfillmentInTransit, false},
+	{"ingresad", shopify.FulfillmentInTransit, false},
+	{"en centro", shopify.FulfillmentInTransit, false},
+}
+
+// lookupShipmentState returns the mapping of the Andreani state, nil when
+// the state has no shopify equivalent.
+func lookupShipmentState(state string) *shipmentState {
+	normalized := normalizeText(state)
+	for i := range shipmentStates {
+		if strings.Contains(normalized, shipmentStates[i].match) {
+			return &shipmentStates[i]
+		}
+	}
+	return nil
+}
+
+// shippingFulfillment returns the shopify fulfillment of the shipping, the
+// one carrying one of its shipping numbers. Fulfillments are never created
+// here, that would fulfill and notify the customer behind the merchant.
+func (app *Application) shippingFulfillment(token string, shipping *database.Shipping) (string, error) {
+	fulfillmentID, err := app.db.GetShippingFulfillment(shipping.ShippingID)
+	if err == nil {
+		return fulfillmentID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	numbers := shippingNumbers(shipping)
+	if len(numbers) == 0 {
+		return "", errors.New("shipping without shipping numbers")
+	}
+
+	order, err := app.db.GetOrder(app.shop, shipping.OrderID)
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := app.shopApi.GetOrderFulfillments(app.shop, token, order.OrderApiID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, fulfillment := range existing {
+		if fulfillment.Status == shopify.FulfillmentCancelled {
+			continue
+		}
+		for _, tracking := range fulfillment.TrackingInfo {
+			for _, number := range numbers {
+				if tracking.Number == number {
+					return fulfillment.ID, app.db.SetShippingFulfillment(shipping.ShippingID, fulfillment.ID)
+				}
+			}
+		}
+	}
+
+	return "", ErrNoFulfillment
+}
+
+// postShipmentEvents sends the stored events not sent yet to the shopify
+// fulfillment of the shipping.
+func (app *Application) postShipmentEvents(token string, shipping *database.Shipping) (int, error) {
+	events, err := app.db.GetUnpostedShipmentEvents(shipping.ShippingID)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	fulfillmentID, err := app.shippingFulfillment(token, shipping)
+	if errors.Is(err, ErrNoFulfillment) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	for _, event := range events {
+		if mapped := lookupShipmentState(event.State); mapped != nil {
+			message := event.State
+			if event.Reason != nil {
+				message += ": " + *event.Reason
+			}
+
+			if err := app.shopApi.FulfillmentEventCreate(app.shop, token, shopify.FulfillmentEvent{
+				FulfillmentID: fulfillmentID,
+				Status:        mapped.status,
+				HappenedAt:    event.OccurredAt,
+				Message:       message,
+			}); err != nil {
+				return posted, err
+			}
+			posted++
+		}
+
+		if err := app.db.MarkShipmentEventPosted(event.EventID); err != nil {
+			return posted, err
+		}
+	}
+
+	return posted, nil
+}
+
+// pollShipment refreshes the shipping events when the package states changed,
+// posts the ones not sent yet to shopify and marks the order as delivered
+// when Andreani delivered it.
+func (app *Application) pollShipment(ctx context.Context, api *andreani.Api, token string, shipping *database.Shipping) (int, error) {
+	if _, err := app.refreshShipmentEvents(ctx, api, shipping); err != nil {
+		return 0, err
+	}
+
+	posted, err := app.postShipmentEvents(token, shipping)
+	if err != nil {
+		return posted, err
+	}
+
+	mapped := lookupShipmentState(shipping.State)
+	if mapped == nil || mapped.status != shopify.FulfillmentDelivered {
+		return posted, nil
+	}
+
+	events, err := app.db.GetShipmentEvents(shipping.ShippingID)
+	if err != nil {
+		return posted, err
+	}
+
+	deliveredAt := time.Now()
+	if len(events) > 0 {
+		deliveredAt = events[len(events)-1].OccurredAt
+	}
+
+	return posted, app.db.DeliverOrder(shipping.OrderID, deliveredAt)
+}
+
+// PollShipments syncs every shipping not delivered nor closed yet and returns
+// the number of events posted to shopify.
+func (app *Application) PollShipments(ctx context.Context) (int, error) {
+	token, err := app.db.GetAccessToken(app.shop)
+	if err != nil {
+		return 0, err
+	}
+
+	api, _, err := app.andreaniApi(app.shop)
+	if err != nil {
+		return 0, err
+	}
+
+	ids, err := app.db.GetUndeliveredShippings(app.shop)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, id := range ids {
+		shipping, err := app.db.GetShipping(app.shop, id)
+		if err != nil {
+			log.Printf("poll shipping %d: %s\n", id, err.Error())
+			continue
+		}
+
+		// Delivered shippings still run once to mark their order.
+		mapped := lookupShipmentState(shipping.State)
+		if mapped != nil && mapped.terminal && mapped.status != shopify.FulfillmentDelivered {
+			continue
+		}
+
+		posted, err := app.pollShipment(ctx, api, token.Access, shipping)
+		total += posted
+		if err != nil {
+			log.Printf("poll shipping %d: %s\n", id, err.Error())
+		}
+	}
+
+	return total, nil
+}
+
+func (app *Application) RunTrackingPoller(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		posted, err := app.PollShipments(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("tracking poll failed: %s\n", err.Error())
+		} else {
+			log.Printf("tracking poll finished: %d events posted\n", posted)
+		}
+		<-ticker.C
+	}
+}
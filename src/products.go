@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"errors"
+	"context"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/database"
+	"tomi/src/shopify"
+)
+
+func productGid(productID int64) string {
+	return fmt.Sprintf("gid://shopify/Product/%d", productID)
+}
+
+func variantGid(variantID int64) string {
+	return fmt.Sprintf("gid://shopify/ProductVariant/%d", variantID)
+}
+
+func (app *Application) storeProductDimensions(shop string, productID int64, dim *shopify.DimensionCm) error {
+	return app.db.UpsertProductDimensions(&database.ProductDimensions{
+		Shop:      shop,
+		ProductID: productID,
+		Width:     dim.Width,
+		Height:    dim.Height,
+		Length:    dim.Length,
+	})
+}
+
+// recordDimensions keeps the dimension issues table in sync with the last
+// validation of a product or variant, request errors are not recorded.
+func (app *Application) recordDimensions(shop string, productID, variantID int64, err error) {
+	var dimErr *shopify.DimensionError
+	if err == nil {
+		err = app.db.DeleteDimensionIssue(shop, productID, variantID)
+	} else if errors.As(err, &dimErr) {
+		err = app.db.UpsertDimensionIssue(&database.DimensionIssue{
+			Shop:      shop,
+			ProductID: productID,
+			VariantID: variantID,
+			Field:     dimErr.Field,
+			Message:   dimErr.Message,
+		})
+	} else {
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// missingDimensionsTTL is how long a product known to have no usable
+// dimensions is not asked for again, product webhooks and the dimension sync
+// refresh it earlier.
+const missingDimensionsTTL = 15 * time.Minute
+
+// productDimensions reads the cached dimensions of a product and only asks
+// shopify for them on a cache miss. Products without usable dimensions are
+// cached too, as their recorded dimension issue.
+func (app *Application) productDimensions(ctx context.Context, token, shop string, productID int64) (*shopify.DimensionCm, error) {
+	cached, err := app.db.GetProductDimensions(shop, productID)
+	if err == nil {
+		dim := &shopify.DimensionCm{
+			Width:  cached.Width,
+			Height: cached.Height,
+			Length: cached.Length,
+		}
+		return dim, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	issue, err := app.db.GetDimensionIssue(shop, productID, 0)
+	if err == nil && time.Since(issue.UpdatedAt) < missingDimensionsTTL {
+		return nil, &shopify.DimensionError{Field: issue.Field, Message: issue.Message}
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	dim, err := app.shopApi.GetProductDimensions(ctx, shop, token, productGid(productID))
+	app.recordDimensions(shop, productID, 0, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.storeProductDimensions(shop, productID, dim); err != nil {
+		log.Println(err.Error())
+	}
+
+	return dim, nil
+}
+
+func (app *Application) storeVariantDimensions(shop string, variantID, productID int64, dim *shopify.DimensionCm, grams *int64) error {
+	variant := &database.VariantDimensions{
+		Shop:      shop,
+		VariantID: variantID,
+		ProductID: productID,
+		Grams:     grams,
+	}
+	if dim != nil {
+		variant.Width = &dim.Width
+		variant.Height = &dim.Height
+		variant.Length = &dim.Length
+	}
+	return app.db.UpsertVariantDimensions(variant)
+}
+
+// variantDimensions reads the cached variant, on a cache miss the variant
+// and its product are fetched together and both get cached.
+func (app *Application) variantDimensions(ctx context.Context, token, shop string, variantID int64) (*database.VariantDimensions, error) {
+	cached, err := app.db.GetVariantDimensions(shop, variantID)
+	if err == nil {
+		return cached, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	variant, err := app.shopApi.GetVariantDimensions(ctx, shop, token, variantGid(variantID))
+	if err != nil {
+		return nil, err
+	}
+
+	app.recordDimensions(shop, variant.ProductID, 0, variant.ProductError)
+	app.recordDimensions(shop, variant.ProductID, variantID, variant.DimensionsError)
+
+	if variant.Product != nil {
+		if err := app.storeProductDimensions(shop, variant.ProductID, variant.Product); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	if err := app.storeVariantDimensions(shop, variantID, variant.ProductID, variant.Dimensions, variant.Grams); err != nil {
+		log.Println(err.Error())
+	}
+
+	return app.db.GetVariantDimensions(shop, variantID)
+}
+
+// itemDimensions resolves the dimensions of a cart item looking at the
+// variant first, then the product and finally the shop defaults.
+func (app *Application) itemDimensions(ctx context.Context, token, shop string, item PackageItem) (*shopify.DimensionCm, error) {
+	if item.VariantID != 0 {
+		variant, err := app.variantDimensions(ctx, token, shop, item.VariantID)
+		if err != nil {
+			log.Println(err.Error())
+		} else if variant.Width != nil && variant.Height != nil && variant.Length != nil {
+			dim := &shopify.DimensionCm{
+				Width:  *variant.Width,
+				Height: *variant.Height,
+				Length: *variant.Length,
+			}
+			return dim, nil
+		}
+	}
+
+	dim, productErr := app.productDimensions(ctx, token, shop, item.ProductID)
+	if productErr == nil {
+		return dim, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	defaults, err := app.db.GetDimensionDefaults(shop)
+	if err != nil {
+		return nil, productErr
+	}
+
+	dim = &shopify.DimensionCm{
+		Width:  defaults.Width,
+		Height: defaults.Height,
+		Length: defaults.Length,
+	}
+	return dim, nil
+}
+
+// itemGrams uses the grams sent by shopify and falls back to the variant
+// weight and the shop defaults when they are missing.
+func (app *Application) itemGrams(ctx context.Context, token, shop string, item PackageItem) int64 {
+	if item.Grams > 0 {
+		return item.Grams
+	}
+
+	if item.VariantID != 0 {
+		variant, err := app.variantDimensions(ctx, token, shop, item.VariantID)
+		if err != nil {
+			log.Println(err.Error())
+		} else if variant.Grams != nil {
+			return *variant.Grams
+		}
+	}
+
+	defaults, err := app.db.GetDimensionDefaults(shop)
+	if err != nil {
+		return 0
+	}
+	return defaults.Grams
+}
+
+func (app *Application) OnProductEvent(shop string, product *shopify.ProductWebhook) error {
+	var dim *shopify.DimensionCm
+	var err error
+
+	if product.Metafields != nil {
+		dim, err = product.Dimensions()
+	} else {
+		token, tokenErr := app.db.GetAccessToken(shop)
+		if tokenErr != nil {
+			return tokenErr
+		}
+		dim, err = app.shopApi.GetProductDimensions(context.Background(), shop, token.Access, productGid(product.ID))
+	}
+	app.recordDimensions(shop, product.ID, 0, err)
+
+	if err != nil {
+		// Stale dimensions are worse than a cache miss.
+		if err := app.db.DeleteProductDimensions(shop, product.ID); err != nil {
+			return err
+		}
+		if err := app.db.DeleteProductVariantDimensions(shop, product.ID); err != nil {
+			return err
+		}
+		return fmt.Errorf("product %d: %s", product.ID, err.Error())
+	}
+
+	if err := app.storeProductDimensions(shop, product.ID, dim); err != nil {
+		return err
+	}
+
+	// Variant metafields are not part of the payload, drop the cached
+	// variants so they are fetched again on the next rate request.
+	if err := app.db.DeleteProductVariantDimensions(shop, product.ID); err != nil {
+		return err
+	}
+
+	log.Printf("product dimensions updated: %d\n", product.ID)
+	return nil
+}
+
+func (app *Application) SyncProductDimensions() (*shopify.BulkOperation, error) {
+	return app.startBulkOperation(app.shop, "product dimensions sync", shopify.ProductDimensionsBulkQuery, func(op *shopify.BulkOperation) error {
+		products := 0
+		variants := 0
+		onProduct := func(product *shopify.BulkProduct) error {
+			dim, err := product.Dimensions()
+			app.recordDimensions(app.shop, product.ProductID(), 0, err)
+			if err != nil {
+				return app.db.DeleteProductDimensions(app.shop, product.ProductID())
+			}
+			if err := app.storeProductDimensions(app.shop, product.ProductID(), dim); err != nil {
+				return err
+			}
+			products++
+			return nil
+		}
+		onVariant := func(variant *shopify.BulkVariant) error {
+			dim, err := variant.Dimensions()
+			app.recordDimensions(app.shop, variant.ProductID(), variant.VariantID(), err)
+			if err := app.storeVariantDimensions(
+				app.shop,
+				variant.VariantID(),
+				variant.ProductID(),
+				dim,
+				variant.Grams(),
+			); err != nil {
+				return err
+			}
+			variants++
+			return nil
+		}
+		err := shopify.ReadBulkProducts(*op.Url, onProduct, onVariant)
+		log.Printf("product dimensions sync: %d products, %d variants\n", products, variants)
+		return err
+	})
+}
+
+func (app *Application) SyncProductDimensionsHandler(w http.ResponseWriter, r *http.Request) {
+	op, err := app.SyncProductDimensions()
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(op); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) GetDimensionIssuesHandler(w http.ResponseWriter, r *http.Request) {
+	issues, err := app.db.GetDimensionIssues(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(issues); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"strings"
+
+	"sync/atomic"
+
+	"encoding/json"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+const defaultRateCacheTTL = 30 * time.Minute
+
+type rateCacheEntry struct {
+	shop      string
+	rate      andreani.Rate
+	expiresAt time.Time
+}
+
+// RateCache keeps Andreani quotes for a while so checkout refreshes don't
+// hit the api again. With a database the entries survive restarts.
+type RateCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]rateCacheEntry
+	db      *database.Database
+
+	Hits   atomic.Int64
+	Misses atomic.Int64
+}
+
+func NewRateCache(ttl time.Duration, db *database.Database) *RateCache {
+	return &RateCache{
+		ttl:     ttl,
+		entries: map[string]rateCacheEntry{},
+		db:      db,
+	}
+}
+
+// newRateCacheFromEnv reads RATE_CACHE_TTL and RATE_CACHE_SQLITE.
+func newRateCacheFromEnv(db *database.Database) *RateCache {
+	ttl, err := time.ParseDuration(os.Getenv("RATE_CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = defaultRateCacheTTL
+	}
+	if os.Getenv("RATE_CACHE_SQLITE") != "true" {
+		db = nil
+	}
+	return NewRateCache(ttl, db)
+}
+
+// rateCacheKey groups quotes by contract, destination and the volume and
+// weight bucket of every parcel.
+func rateCacheKey(shop, contract string, req QuoteRequest) string {
+	parts := []string{shop, contract, req.OriginBranch, req.Zip}
+	for _, bulto := range req.Bultos {
+		_, volume := bucket(bulto.VolumenCm, volumeBucketSize)
+		_, kilos := bucket(bulto.Kilos, kilosBucketSize)
+		parts = append(parts, fmt.Sprintf("%.0f/%.0f", volume, kilos))
+	}
+	return strings.Join(parts, "|")
+}
+
+func (c *RateCache) Get(key string) (*andreani.Rate, bool) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok && c.db != nil {
+		stored, err := c.db.GetRateCacheEntry(key)
+		if err == nil && now.Before(stored.ExpiresAt) {
+			entry = rateCacheEntry{shop: stored.Shop, expiresAt: stored.ExpiresAt}
+			ok = json.Unmarshal([]byte(stored.Rate), &entry.rate) == nil
+			if ok {
+				c.mu.Lock()
+				c.entries[key] = entry
+				c.mu.Unlock()
+			}
+		}
+	}
+
+	if !ok {
+		c.Misses.Add(1)
+		return nil, false
+	}
+
+	c.Hits.Add(1)
+	rate := entry.rate
+	return &rate, true
+}
+
+func (c *RateCache) Set(shop, key string, rate *andreani.Rate) {
+	entry := rateCacheEntry{
+		shop:      shop,
+		rate:      *rate,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	if c.db == nil {
+		return
+	}
+
+	body, err := json.Marshal(rate)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	if err := c.db.UpsertRateCacheEntry(&database.RateCacheEntry{
+		Key:       key,
+		Shop:      shop,
+		Rate:      string(body),
+		ExpiresAt: entry.expiresAt,
+	}); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// InvalidateShop drops every quote of the shop, used when its contracts change.
+func (c *RateCache) InvalidateShop(shop string) {
+	c.mu.Lock()
+	for key, entry := range c.entries {
+		if entry.shop == shop {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.db == nil {
+		return
+	}
+
+	if err := c.db.DeleteShopRateCache(shop); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Purge removes the expired entries so the cache doesn't grow forever.
+func (c *RateCache) Purge() {
+	now := time.Now()
+
+	c.mu.Lock()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.db == nil {
+		return
+	}
+
+	if err := c.db.DeleteExpiredRateCache(now); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func (c *RateCache) RunPurge() {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.Purge()
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"log"
+	"time"
+
+	"tomi/src/database"
+)
+
+const (
+	quoteBatchSize     = 64
+	quoteFlushInterval = 5 * time.Second
+	quoteBufferSize    = 1024
+
+	defaultQuoteRetention = 90 * 24 * time.Hour
+)
+
+// QuoteRecorder stores the Andreani quotes in batches off the checkout path,
+// quotes arriving while the buffer is full are dropped.
+type QuoteRecorder struct {
+	db        *database.Database
+	quotes    chan database.RateQuote
+	retention time.Duration
+}
+
+func NewQuoteRecorder(db *database.Database, retention time.Duration) *QuoteRecorder {
+	return &QuoteRecorder{
+		db:        db,
+		quotes:    make(chan database.RateQuote, quoteBufferSize),
+		retention: retention,
+	}
+}
+
+// newQuoteRecorderFromEnv reads RATE_QUOTE_RETENTION.
+func newQuoteRecorderFromEnv(db *database.Database) *QuoteRecorder {
+	retention, err := time.ParseDuration(os.Getenv("RATE_QUOTE_RETENTION"))
+	if err != nil || retention <= 0 {
+		retention = defaultQuoteRetention
+	}
+	return NewQuoteRecorder(db, retention)
+}
+
+func (r *QuoteRecorder) Record(quote database.RateQuote) {
+	select {
+	case r.quotes <- quote:
+	default:
+		log.Printf("quote %s dropped, recorder buffer full\n", quote.ServiceCode)
+	}
+}
+
+func (r *QuoteRecorder) flush(batch []database.RateQuote) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := r.db.InsertRateQuotes(batch); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// Run writes the recorded quotes every quoteBatchSize quotes or every
+// quoteFlushInterval, whichever comes first.
+func (r *QuoteRecorder) Run() {
+	ticker := time.NewTicker(quoteFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]database.RateQuote, 0, quoteBatchSize)
+	for {
+		select {
+		case quote := <-r.quotes:
+			batch = append(batch, quote)
+			if len(batch) < quoteBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		r.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// Purge removes the quotes older than the retention, their prices are too
+// stale for the fallback rates anyway.
+func (r *QuoteRecorder) Purge() {
+	n, err := r.db.DeleteRateQuotesBefore(time.Now().Add(-r.retention))
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	if n > 0 {
+		log.Printf("rate quotes purged: %d\n", n)
+	}
+}
+
+func (r *QuoteRecorder) RunPurge() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		r.Purge()
+		<-ticker.C
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"tomi/src/money"
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+type ShippingService struct {
+	Code        string
+	Name        string
+	Description string
+	Contract    string
+}
+
+type CarrierRate struct {
+	ServiceName string `json:"service_name"`
+	ServiceCode string `json:"service_code"`
+	TotalPrice  string `json:"total_price"`
+	Description string `json:"description"`
+	Currency    string `json:"currency"`
+
+	MinDeliveryDate string `json:"min_delivery_date,omitempty"`
+	MaxDeliveryDate string `json:"max_delivery_date,omitempty"`
+}
+
+// configuredServices returns the Andreani services the shop has a contract for.
+func configuredServices(settings *database.CarrierSettings) []ShippingService {
+	candidates := []ShippingService{
+		{
+			Code:        "andreani-home",
+			Name:        "Andreani a domicilio",
+			Description: "envio directo a tu domicilio",
+			Contract:    settings.ContractHome,
+		},
+		{
+			Code:        "andreani-branch",
+			Name:        "Andreani retiro en sucursal",
+			Description: "retira tu pedido en la sucursal Andreani mas cercana",
+			Contract:    settings.ContractBranch,
+		},
+		{
+			Code:        "andreani-express",
+			Name:        "Andreani express",
+			Description: "envio express a tu domicilio",
+			Contract:    settings.ContractExpress,
+		},
+	}
+
+	services := []ShippingService{}
+	for _, service := range candidates {
+		if service.Contract != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+type QuoteRequest struct {
+	Zip          string
+	Province     string
+	OriginBranch string
+	Bultos       []andreani.Bulto
+}
+
+func (req *QuoteRequest) Volume() float64 {
+	var volume float64 = 0
+	for _, bulto := range req.Bultos {
+		volume += bulto.VolumenCm
+	}
+	return volume
+}
+
+func (req *QuoteRequest) Kilos() float64 {
+	var kilos float64 = 0
+	for _, bulto := range req.Bultos {
+		kilos += bulto.Kilos
+	}
+	return kilos
+}
+
+func (service *ShippingService) rate(price money.Money) *CarrierRate {
+	return &CarrierRate{
+		ServiceName: service.Name,
+		ServiceCode: service.Code,
+		TotalPrice:  price.Minor(),
+		Description: service.Description,
+		Currency:    price.Currency,
+	}
+}
+
+func (app *Application) quoteService(ctx context.Context, api *andreani.Api, settings *database.CarrierSettings, service ShippingService, req QuoteRequest) (*CarrierRate, error) {
+	key := rateCacheKey(settings.Shop, service.Contract, req)
+
+	rate, ok := app.rateCache.Get(key)
+	if !ok {
+		var err error
+		rate, err = api.CalculateShippingRate(ctx, andreani.RateQuery{
+			Contract:     service.Contract,
+			Zip:          req.Zip,
+			OriginBranch: req.OriginBranch,
+			Bultos:       req.Bultos,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		app.recordQuote(settings.Shop, service, req, rate)
+		app.rateCache.Set(settings.Shop, key, rate)
+	}
+
+	price, err := rate.TarifaConIva.TotalMoney()
+	if err != nil {
+		return nil, err
+	}
+
+	return service.rate(price), nil
+}
+
+// recordQuote keeps the volumetric weight Andreani charged so prices can be
+// explained later.
+func (app *Application) recordQuote(shop string, service ShippingService, req QuoteRequest, rate *andreani.Rate) {
+	quote := database.RateQuote{
+		Shop:        shop,
+		ServiceCode: service.Code,
+		Contract:    service.Contract,
+		Zip:         req.Zip,
+		Volume:      req.Volume(),
+		Kilos:       req.Kilos(),
+		PesoAforado: rate.PesoAforado,
+		Total:       rate.TarifaConIva.Total,
+	}
+
+	log.Printf(
+		"quote %s: zip %s, %.2f cm3, %.3f kg, peso aforado %s, total %s\n",
+		service.Code, req.Zip, quote.Volume, quote.Kilos, quote.PesoAforado, quote.Total,
+	)
+
+	app.quotes.Record(quote)
+}
+
+// quoteServices asks Andreani for every service at the same time until ctx
+// is done, services that fail use the fallback rate or are left out.
+func (app *Application) quoteServices(ctx context.Context, fallback *fallbackSettings, api *andreani.Api, settings *database.CarrierSettings, req QuoteRequest) []CarrierRate {
+	services := configuredServices(settings)
+
+	results := make([]*CarrierRate, len(services))
+
+	var wg sync.WaitGroup
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int, service ShippingService) {
+			defer wg.Done()
+			rate, err := app.quoteService(ctx, api, settings, service, req)
+			if err != nil {
+				log.Printf("quote %s failed: %s\n", service.Code, err.Error())
+				rate = app.fallbackRate(fallback, service, req)
+			}
+			results[i] = rate
+		}(i, service)
+	}
+	wg.Wait()
+
+	rates := []CarrierRate{}
+	for _, rate := range results {
+		if rate != nil {
+			rates = append(rates, *rate)
+		}
+	}
+	return rates
+}
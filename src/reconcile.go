@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"log"
+	"time"
+	"errors"
+
+	"database/sql"
+
+	"tomi/src/database"
+)
+
+const defaultReconcileInterval = 30 * time.Minute
+
+func reconcileInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("RECONCILE_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return defaultReconcileInterval
+	}
+	return interval
+}
+
+type remoteOrder interface {
+	ToDatabaseOrder(shop string) database.Order
+	IsPaid() bool
+	IsFulfilled() bool
+	IsCancelled() bool
+}
+
+// reconcileOrder repairs the local copy of a shopify order and returns the
+// number of corrections applied to it: one for an order missing locally, one
+// per flag that differed otherwise. Newer order fields are updated without
+// counting as a correction.
+//
+// Fulfilled and cancelled follow shopify both ways. Paid is only ever set,
+// refunds keep the order paid locally.
+func (app *Application) reconcileOrder(remote remoteOrder, shop string) (int, error) {
+	order := remote.ToDatabaseOrder(shop)
+
+	if app.db.OrderWasDeleted(order.OrderID) {
+		return 0, nil
+	}
+
+	missing := false
+	local, err := app.db.GetOrderState(order.OrderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		local = &database.OrderState{}
+		missing = true
+	} else if err != nil {
+		return 0, err
+	}
+
+	if err := app.upsertOrder(&order); err != nil {
+		return 0, err
+	}
+
+	corrections := 0
+
+	if remote.IsPaid() && !local.Paid {
+		if err := app.db.PayOrder(&order); err != nil {
+			return corrections, err
+		}
+		corrections++
+	}
+
+	if fulfilled := remote.IsFulfilled(); fulfilled != local.Fulfilled {
+		update := app.db.FulfillOrder
+		if !fulfilled {
+			update = app.db.UnfulfillOrder
+		}
+		if err := update(&order); err != nil {
+			return corrections, err
+		}
+		corrections++
+	}
+
+	if cancelled := remote.IsCancelled(); cancelled != local.Cancelled {
+		update := app.db.CancelOrder
+		if !cancelled {
+			update = app.db.UncancelOrder
+		}
+		if err := update(&order); err != nil {
+			return corrections, err
+		}
+		corrections++
+	}
+
+	if missing {
+		return 1, nil
+	}
+	return corrections, nil
+}
+
+func (app *Application) ReconcileOrders(since time.Time) (int, error) {
+	token, err := app.db.GetAccessToken(app.shop)
+	if err != nil {
+		return 0, err
+	}
+
+	orders, err := app.shopApi.GetOrdersUpdatedSince(app.shop, token.Access, since)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for i := range orders {
+		corrections, err := app.reconcileOrder(&orders[i], app.shop)
+		total += corrections
+		if err != nil {
+			log.Printf("reconcile order %d: %s\n", orders[i].ID, err.Error())
+		}
+	}
+
+	return total, nil
+}
+
+func (app *Application) RunReconciler(interval time.Duration) {
+	// The first run looks one day back to catch anything missed while the
+	// app was down, later runs overlap the previous window by one interval.
+	since := time.Now().Add(-24 * time.Hour)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		corrections, err := app.ReconcileOrders(since)
+		if err != nil {
+			log.Printf("reconcile failed: %s\n", err.Error())
+		} else {
+			log.Printf("reconcile finished: %d corrections\n", corrections)
+			since = start.Add(-interval)
+		}
+		<-ticker.C
+	}
+}
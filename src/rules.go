@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"errors"
+	"strings"
+	"strconv"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/database"
+)
+
+const (
+	RuleFreeShipping  = "free_shipping"
+	RuleMarkupPercent = "markup_percent"
+	RuleMarkupFlat    = "markup_flat"
+	RuleFlatPrice     = "flat_price"
+	RuleRoundUp       = "round_up"
+	RuleHide          = "hide"
+)
+
+// RuleCart is what the rules are evaluated against, Subtotal is in cents.
+type RuleCart struct {
+	Subtotal int64    `json:"subtotal"`
+	Province string   `json:"province"`
+	Zip      string   `json:"zip"`
+	Skus     []string `json:"skus"`
+}
+
+type RuleResult struct {
+	Rate    CarrierRate `json:"rate"`
+	Hidden  bool        `json:"hidden"`
+	Applied []int64     `json:"applied"`
+}
+
+func validRule(rule *database.RateRule) error {
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	switch rule.Action {
+	case RuleFreeShipping, RuleHide, RuleMarkupPercent, RuleMarkupFlat:
+	case RuleFlatPrice:
+		if rule.Value < 0 {
+			return errors.New("value must not be negative")
+		}
+	case RuleRoundUp:
+		if rule.Value <= 0 {
+			return errors.New("value must be greater than zero")
+		}
+	default:
+		return fmt.Errorf("unknown action %s", rule.Action)
+	}
+	return nil
+}
+
+func ruleMatches(rule *database.RateRule, cart *RuleCart, serviceCode string) bool {
+	if !rule.Active {
+		return false
+	}
+
+	if rule.ServiceCode != nil && *rule.ServiceCode != serviceCode {
+		return false
+	}
+
+	if rule.Province != nil && !strings.EqualFold(*rule.Province, cart.Province) {
+		return false
+	}
+
+	if rule.ZipFrom != nil || rule.ZipTo != nil {
+		zip, err := strconv.ParseInt(postalCode(cart.Zip), 10, 64)
+		if err != nil {
+			return false
+		}
+		if rule.ZipFrom != nil && zip < *rule.ZipFrom {
+			return false
+		}
+		if rule.ZipTo != nil && zip > *rule.ZipTo {
+			return false
+		}
+	}
+
+	if rule.MinSubtotal != nil && cart.Subtotal < *rule.MinSubtotal {
+		return false
+	}
+
+	if rule.MaxSubtotal != nil && cart.Subtotal > *rule.MaxSubtotal {
+		return false
+	}
+
+	if rule.SkuPrefix != nil {
+		found := false
+		for _, sku := range cart.Skus {
+			if strings.HasPrefix(sku, *rule.SkuPrefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func applyRule(rule *database.RateRule, price int64) int64 {
+	switch rule.Action {
+	case RuleFreeShipping:
+		return 0
+	case RuleMarkupPercent:
+		return price + int64(math.Round(float64(price)*rule.Value/100))
+	case RuleMarkupFlat:
+		return price + int64(math.Round(rule.Value))
+	case RuleFlatPrice:
+		return int64(math.Round(rule.Value))
+	case RuleRoundUp:
+		step := int64(math.Round(rule.Value))
+		if step <= 0 || price%step == 0 {
+			return price
+		}
+		return (price/step + 1) * step
+	}
+	return price
+}
+
+// applyRules runs every matching rule in priority order over each rate.
+func applyRules(rules []database.RateRule, cart *RuleCart, rates []CarrierRate) []RuleResult {
+	results := make([]RuleResult, 0, len(rates))
+	for _, rate := range rates {
+		result := RuleResult{Rate: rate, Applied: []int64{}}
+
+		price, err := strconv.ParseInt(rate.TotalPrice, 10, 64)
+		if err != nil {
+			results = append(results, result)
+			continue
+		}
+
+		for i := range rules {
+			rule := &rules[i]
+			if !ruleMatches(rule, cart, rate.ServiceCode) {
+				continue
+			}
+			result.Applied = append(result.Applied, rule.RuleID)
+			if rule.Action == RuleHide {
+				result.Hidden = true
+				break
+			}
+			price = applyRule(rule, price)
+		}
+
+		if price < 0 {
+			price = 0
+		}
+		result.Rate.TotalPrice = strconv.FormatInt(price, 10)
+		results = append(results, result)
+	}
+	return results
+}
+
+func (app *Application) applyShopRules(shop string, cart *RuleCart, rates []CarrierRate) ([]CarrierRate, error) {
+	rules, err := app.db.GetRateRules(shop)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := []CarrierRate{}
+	for _, result := range applyRules(rules, cart, rates) {
+		if !result.Hidden {
+			visible = append(visible, result.Rate)
+		}
+	}
+	return visible, nil
+}
+
+func (app *Application) GetRateRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.db.GetRateRules(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rules); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) CreateRateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule := database.RateRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.Shop = app.shop
+
+	if err := app.db.InsertRateRule(&rule); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(rule); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) UpdateRateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("ruleID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	rule := database.RateRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.RuleID = id
+	rule.Shop = app.shop
+
+	err = app.db.UpdateRateRule(&rule)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rule); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) DeleteRateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("ruleID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.db.DeleteRateRule(app.shop, id); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestRateRulesHandler evaluates the stored rules against a sample cart and
+// sample rates without calling Andreani.
+func (app *Application) TestRateRulesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Cart  RuleCart      `json:"cart"`
+		Rates []CarrierRate `json:"rates"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := app.db.GetRateRules(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	results := applyRules(rules, &payload.Cart, payload.Rates)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
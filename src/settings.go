@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"errors"
+	"strings"
+	"strconv"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+func (app *Application) GetDimensionDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	defaults, err := app.db.GetDimensionDefaults(app.shop)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "dimension defaults not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(defaults); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutDimensionDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	var defaults database.DimensionDefaults
+	if err := json.NewDecoder(r.Body).Decode(&defaults); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if defaults.Width <= 0 || defaults.Height <= 0 || defaults.Length <= 0 || defaults.Grams < 0 {
+		http.Error(w, "invalid dimensions", http.StatusBadRequest)
+		return
+	}
+	defaults.Shop = app.shop
+
+	if err := app.db.UpsertDimensionDefaults(&defaults); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(defaults); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+// AndreaniApis keeps one andreani.Api per shop built from its carrier settings.
+type AndreaniApis struct {
+	mu      sync.Mutex
+	baseUrl string
+	apis    map[string]*andreani.Api
+}
+
+func NewAndreaniApis(baseUrl string) *AndreaniApis {
+	return &AndreaniApis{
+		baseUrl: baseUrl,
+		apis:    map[string]*andreani.Api{},
+	}
+}
+
+func (a *AndreaniApis) Get(settings *database.CarrierSettings) *andreani.Api {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	api, ok := a.apis[settings.Shop]
+	if !ok {
+		api = andreani.NewApi(andreani.Credentials{
+			ClientCode: settings.ClientCode,
+			Username:   settings.Username,
+			Password:   settings.Password,
+			Token:      settings.AccessToken,
+		}, a.baseUrl)
+		a.apis[settings.Shop] = api
+	}
+	return api
+}
+
+func (a *AndreaniApis) Invalidate(shop string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.apis, shop)
+}
+
+func (app *Application) andreaniApi(shop string) (*andreani.Api, *database.CarrierSettings, error) {
+	settings, err := app.db.GetCarrierSettings(shop)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, fmt.Errorf("andreani settings missing for shop %s", shop)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return app.andApis.Get(settings), settings, nil
+}
+
+func (app *Application) GetAndreaniSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.db.GetCarrierSettings(app.shop)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "andreani settings not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Credentials are write only, MarshalJSON leaves them out.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) CreateAndreaniSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var settings database.CarrierSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if settings.ClientCode == "" || settings.Username == "" || settings.Password == "" {
+		http.Error(w, "client_code, username and password are required", http.StatusBadRequest)
+		return
+	}
+	settings.Shop = app.shop
+
+	if _, err := app.db.GetCarrierSettings(app.shop); err == nil {
+		http.Error(w, "andreani settings already exist", http.StatusConflict)
+		return
+	}
+
+	if err := app.db.InsertCarrierSettings(&settings); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	app.andApis.Invalidate(app.shop)
+	app.rateCache.InvalidateShop(app.shop)
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (app *Application) UpdateAndreaniSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	current, err := app.db.GetCarrierSettings(app.shop)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "andreani settings not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var settings database.CarrierSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	settings.Shop = app.shop
+
+	// Omitted credentials keep their stored value.
+	if settings.Password == "" {
+		settings.Password = current.Password
+	}
+	if settings.AccessToken == "" {
+		settings.AccessToken = current.AccessToken
+	}
+
+	if settings.ClientCode == "" || settings.Username == "" {
+		http.Error(w, "client_code and username are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.db.UpdateCarrierSettings(&settings); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	app.andApis.Invalidate(app.shop)
+	app.rateCache.InvalidateShop(app.shop)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *Application) DeleteAndreaniSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.db.DeleteCarrierSettings(app.shop); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	app.andApis.Invalidate(app.shop)
+	app.rateCache.InvalidateShop(app.shop)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validBox(box *database.Box) bool {
+	return box.Name != "" &&
+		box.Width > 0 && box.Height > 0 && box.Length > 0 &&
+		box.MaxKilos >= 0 && box.TareKilos >= 0
+}
+
+func (app *Application) GetBoxesHandler(w http.ResponseWriter, r *http.Request) {
+	boxes, err := app.db.GetBoxes(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(boxes); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) CreateBoxHandler(w http.ResponseWriter, r *http.Request) {
+	var box database.Box
+	if err := json.NewDecoder(r.Body).Decode(&box); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if !validBox(&box) {
+		http.Error(w, "invalid box", http.StatusBadRequest)
+		return
+	}
+	box.Shop = app.shop
+
+	if err := app.db.InsertBox(&box); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(box); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) UpdateBoxHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("boxID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var box database.Box
+	if err := json.NewDecoder(r.Body).Decode(&box); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if !validBox(&box) {
+		http.Error(w, "invalid box", http.StatusBadRequest)
+		return
+	}
+	box.BoxID = id
+	box.Shop = app.shop
+
+	err = app.db.UpdateBox(&box)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "box not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(box); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) DeleteBoxHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("boxID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.db.DeleteBox(app.shop, id); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *Application) GetSenderSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := app.db.GetSenderSettings(app.shop)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "sender settings not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func (app *Application) PutSenderSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var settings database.SenderSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	settings.Name = strings.TrimSpace(settings.Name)
+	settings.Phone = strings.TrimSpace(settings.Phone)
+	settings.Email = strings.TrimSpace(settings.Email)
+	if settings.Name == "" || settings.Phone == "" {
+		http.Error(w, "sender name and phone are required", http.StatusBadRequest)
+		return
+	}
+	settings.Shop = app.shop
+
+	if err := app.db.UpsertSenderSettings(&settings); err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
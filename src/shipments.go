@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"errors"
+	"regexp"
+	"strings"
+	"context"
+	"strconv"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+	"tomi/src/packing"
+)
+
+// ShipmentRequest is everything Andreani needs to create a pre-envío.
+type ShipmentRequest struct {
+	OrderID     int64
+	Contract    string
+	Origin      andreani.Origin
+	Destination andreani.Postal
+	Sender      andreani.Persona
+	Recipient   andreani.Persona
+	Bultos      []andreani.Bulto
+}
+
+// packageLabel returns the label link Andreani attaches to each bulto.
+func packageLabel(bulto *andreani.OrderBulto) string {
+	for _, link := range bulto.Linking {
+		if strings.EqualFold(link.Meta, "etiqueta") {
+			return link.Contenido
+		}
+	}
+	return ""
+}
+
+func shippingFromOrder(orderID int64, order *andreani.Order) *database.Shipping {
+	shipping := &database.Shipping{
+		OrderID:            orderID,
+		State:              order.Estado,
+		Type:               order.Tipo,
+		PackageGroup:       order.AgrupadorDeBultos,
+		PackageGroupLabels: order.EtiquetasPorAgrupador,
+		Packages:           make([]database.Package, 0, len(order.Bultos)),
+	}
+
+	for i := range order.Bultos {
+		bulto := &order.Bultos[i]
+		shipping.Packages = append(shipping.Packages, database.Package{
+			Number:         bulto.NumeroDeBulto,
+			ShippingNumber: bulto.NumeroDeEnvio,
+			Label:          packageLabel(bulto),
+		})
+	}
+
+	return shipping
+}
+
+// createShipment creates the pre-envío in Andreani and stores the shipping
+// with its packages in a single transaction.
+func (app *Application) createShipment(ctx context.Context, api *andreani.Api, req *ShipmentRequest) (*database.Shipping, error) {
+	order, err := api.CreateShipping(
+		ctx,
+		req.Contract,
+		req.Origin,
+		req.Destination,
+		req.Sender,
+		req.Recipient,
+		req.Bultos,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	shipping := shippingFromOrder(req.OrderID, order)
+	if err := app.db.InsertShipping(shipping); err != nil {
+		// The pre-envío already exists in Andreani, log it so it can be
+		// recovered by hand.
+		log.Printf("order %d: andreani shipping %s not stored: %s\n", req.OrderID, order.AgrupadorDeBultos, err.Error())
+		return nil, err
+	}
+
+	return shipping, nil
+}
+
+func (app *Application) GetOrderShipmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	shippings, err := app.db.GetOrderShippings(app.shop, id)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(shippings); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+// ValidationErrors maps a request field to what is wrong with it.
+type ValidationErrors map[string]string
+
+func writeValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := json.NewEncoder(w).Encode(map[string]ValidationErrors{"errors": errs}); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+func addressValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(*value)
+}
+
+// streetNumberPattern matches the door number customers write after the
+// street, "Av. Corrientes 1234" or "Corrientes N° 1234 3B".
+var streetNumberPattern = regexp.MustCompile(`^(.*?\S)\s*,?\s+(?:(?i:n(?:ro)?)[°º.]?\s*)?([0-9]+)(?:\s+[0-9]{0,2}[A-Za-z])?$`)
+
+// floorPattern finds where the floor and apartment start, "piso 3 dto B".
+var floorPattern = regexp.MustCompile(`(?i)[\s,]+(?:piso|dto|depto|departamento|dpto|pb)\b`)
+
+// splitStreetNumber splits Shopify's single address line in the street and
+// its door number, the number is empty when the line has none.
+func splitStreetNumber(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	street := line
+	if i := strings.Index(street, ","); i >= 0 {
+		street = strings.TrimSpace(street[:i])
+	}
+	if loc := floorPattern.FindStringIndex(street); loc != nil {
+		street = strings.TrimSpace(street[:loc[0]])
+	}
+
+	m := streetNumberPattern.FindStringSubmatch(street)
+	if m == nil {
+		return line, ""
+	}
+	return m[1], m[2]
+}
+
+// shipmentDestination builds the Andreani destination and recipient from the
+// stored shipping address.
+func shipmentDestination(address *database.Address, errs ValidationErrors) (andreani.Postal, andreani.Persona) {
+	var postal andreani.Postal
+	var recipient andreani.Persona
+
+	if address == nil {
+		errs["shipping_address"] = "order has no shipping address"
+		return postal, recipient
+	}
+
+	postal.Calle = addressValue(address.Address1)
+	if postal.Calle == "" {
+		errs["shipping_address.address1"] = "street is required"
+	}
+
+	if address.Number != nil {
+		postal.Numero = strconv.Itoa(*address.Number)
+	} else if postal.Calle != "" {
+		postal.Calle, postal.Numero = splitStreetNumber(postal.Calle)
+		if postal.Numero == "" {
+			errs["shipping_address.number"] = "street number is required"
+		}
+	}
+
+	postal.CodigoPostal = postalCode(addressValue(address.Zip))
+	if postal.CodigoPostal == "" {
+		errs["shipping_address.zip"] = "postal code is required"
+	}
+
+	postal.Localidad = addressValue(address.City)
+	if postal.Localidad == "" {
+		errs["shipping_address.city"] = "city is required"
+	}
+	postal.Region = addressValue(address.Province)
+
+	name := strings.TrimSpace(addressValue(address.Name) + " " + addressValue(address.LastName))
+	if name == "" {
+		errs["shipping_address.name"] = "recipient name is required"
+	}
+
+	recipient.NombreCompleto = name
+	recipient.Email = addressValue(address.Email)
+	recipient.Telefonos = []andreani.Telefono{}
+	if phone := addressValue(address.Phone); phone != "" {
+		recipient.Telefonos = append(recipient.Telefonos, andreani.Telefono{Tipo: 1, Numero: phone})
+	}
+
+	return postal, recipient
+}
+
+// shipmentSender returns the sender configured for the shop, Andreani
+// requires a name and a phone to contact.
+func (app *Application) shipmentSender(errs ValidationErrors) (andreani.Persona, error) {
+	settings, err := app.db.GetSenderSettings(app.shop)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs["sender"] = "sender name and phone are not configured"
+		return andreani.Persona{}, nil
+	}
+	if err != nil {
+		return andreani.Persona{}, err
+	}
+
+	return andreani.Persona{
+		NombreCompleto: settings.Name,
+		Email:          settings.Email,
+		Telefonos:      []andreani.Telefono{{Tipo: 1, Numero: settings.Phone}},
+	}, nil
+}
+
+func shipmentService(settings *database.CarrierSettings, code string) *ShippingService {
+	for _, service := range configuredServices(settings) {
+		if service.Code == code {
+			return &service
+		}
+	}
+	return nil
+}
+
+// CreateOrderShipmentHandler creates the Andreani pre-envío of a stored
+// order. The body can pick the service and the Shopify location the parcels
+// leave from, by default the service the customer chose at checkout is used.
+func (app *Application) CreateOrderShipmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		ServiceCode string `json:"service_code"`
+		LocationID  int64  `json:"location_id"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	order, err := app.db.GetOrder(app.shop, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := app.db.GetAccessToken(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	api, settings, err := app.andreaniApi(app.shop)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "andreani is not configured", http.StatusConflict)
+		return
+	}
+
+	errs := ValidationErrors{}
+
+	if order.Cancelled {
+		errs["order"] = "order is cancelled"
+	}
+
+	code := payload.ServiceCode
+	if code == "" && order.CarrierCode != nil {
+		code = *order.CarrierCode
+	}
+
+	service := shipmentService(settings, code)
+	if service == nil {
+		errs["service_code"] = fmt.Sprintf("no andreani contract for service %q", code)
+	}
+
+	// Without an explicit location the order ships from the one shopify
+	// assigned it to.
+	locationID := payload.LocationID
+	if locationID == 0 {
+		locationID, err = app.orderLocationID(token.Access, order)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	origin, err := app.shipmentOrigin(app.shop, locationID, settings)
+	if err != nil {
+		errs["origin"] = err.Error()
+	}
+
+	destination, recipient := shipmentDestination(order.ShippingAddress, errs)
+
+	sender, err := app.shipmentSender(errs)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	issues, err := app.addressIssues(order.ShippingAddress)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for field, issue := range issues {
+		if _, ok := errs[field]; !ok {
+			errs[field] = issue
+		}
+	}
+
+	items := make([]PackageItem, 0, len(order.Items))
+	for _, item := range order.Items {
+		packageItem := PackageItem{
+			ProductID: item.ProductID,
+			Grams:     item.Grams,
+			Quantity:  int(item.Quantity),
+		}
+		if item.VariantID != nil {
+			packageItem.VariantID = *item.VariantID
+		}
+		items = append(items, packageItem)
+	}
+
+	var bultos []andreani.Bulto
+	if len(items) == 0 {
+		errs["items"] = "order has no items"
+	} else {
+		bultos, err = app.packItems(r.Context(), token.Access, app.shop, items)
+		var missing *MissingDimensionsError
+		var oversized *packing.OversizedError
+		if errors.As(err, &missing) {
+			for _, item := range missing.Items {
+				errs[fmt.Sprintf("items[%d].dimensions", item.Index)] = fmt.Sprintf("product %d has no valid dimensions: %s", item.ProductID, item.Reason)
+			}
+		} else if errors.As(err, &oversized) {
+			errs["bultos"] = oversized.Error()
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		for i, bulto := range bultos {
+			if bulto.VolumenCm <= 0 {
+				errs[fmt.Sprintf("bultos[%d].volumen", i)] = "products are missing dimensions"
+			}
+			if bulto.Kilos <= 0 {
+				errs[fmt.Sprintf("bultos[%d].kilos", i)] = "products are missing weight"
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	shipping, err := app.createShipment(r.Context(), api, &ShipmentRequest{
+		OrderID:     order.OrderID,
+		Contract:    service.Contract,
+		Origin:      *origin,
+		Destination: destination,
+		Sender:      sender,
+		Recipient:   recipient,
+		Bultos:      bultos,
+	})
+	var apiErr *andreani.Error
+	if errors.As(err, &apiErr) && len(apiErr.Fields) > 0 {
+		for field, message := range apiErr.Fields {
+			errs["andreani."+field] = message
+		}
+		writeValidationErrors(w, errs)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "andreani shipment failed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(shipping); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
+
+// cancelledShipmentState is the state stored for shippings cancelled from
+// the app, the records are kept for audit.
+const cancelledShipmentState = "Cancelada"
+
+// cancellableStates are the pre-envío states Andreani still cancels, once the
+// package is admitted it has to be returned instead.
+var cancellableStates = []string{"pendiente", "solicitad", "creada", "generad"}
+
+func cancellableShipment(state string) bool {
+	normalized := normalizeText(state)
+	for _, match := range cancellableStates {
+		if strings.Contains(normalized, match) {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelShipmentFulfillment cancels the shopify fulfillment carrying the
+// shipping tracking numbers, if any. It is looked up in shopify when the
+// tracking poller didn't match it yet.
+func (app *Application) cancelShipmentFulfillment(shipping *database.Shipping) error {
+	if len(shippingNumbers(shipping)) == 0 {
+		return nil
+	}
+
+	token, err := app.db.GetAccessToken(app.shop)
+	if err != nil {
+		return err
+	}
+
+	fulfillmentID, err := app.shippingFulfillment(token.Access, shipping)
+	if errors.Is(err, ErrNoFulfillment) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := app.shopApi.FulfillmentCancel(app.shop, token.Access, fulfillmentID); err != nil {
+		return err
+	}
+
+	return app.db.DeleteShippingFulfillment(shipping.ShippingID)
+}
+
+// CancelShipmentHandler cancels the Andreani pre-envío and the shopify
+// fulfillment of a shipment. A shipment already cancelled only retries the
+// shopify side.
+func (app *Application) CancelShipmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	shipping, err := app.db.GetShipping(app.shop, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "shipment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if shipping.State != cancelledShipmentState {
+		if !cancellableShipment(shipping.State) {
+			http.Error(w, fmt.Sprintf("shipment can no longer be cancelled in state %q", shipping.State), http.StatusConflict)
+			return
+		}
+
+		api, _, err := app.andreaniApi(app.shop)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "andreani is not configured", http.StatusConflict)
+			return
+		}
+
+		cancelled, err := app.db.GetCancelledShippingNumbers(shipping.ShippingID)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Numbers cancelled by a previous attempt are skipped, Andreani
+		// rejects cancelling them again.
+		for _, number := range shippingNumbers(shipping) {
+			if cancelled[number] {
+				continue
+			}
+
+			err := api.CancelShipping(r.Context(), number)
+			var apiErr *andreani.Error
+			if errors.As(err, &apiErr) && apiErr.Status >= 400 && apiErr.Status < 500 {
+				http.Error(w, "andreani rejected the cancel: "+apiErr.Message, http.StatusConflict)
+				return
+			}
+			if err != nil {
+				log.Printf("shipping %d: %s\n", shipping.ShippingID, err.Error())
+				http.Error(w, "andreani cancel failed", http.StatusBadGateway)
+				return
+			}
+
+			if err := app.db.CancelShippingNumber(shipping.ShippingID, number); err != nil {
+				log.Println(err.Error())
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := app.db.UpdateShippingState(shipping.ShippingID, cancelledShipmentState); err != nil {
+			log.Println(err.Error())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		shipping.State = cancelledShipmentState
+	}
+
+	if err := app.cancelShipmentFulfillment(shipping); err != nil {
+		log.Printf("shipping %d: %s\n", shipping.ShippingID, err.Error())
+		http.Error(w, "shopify fulfillment cancel failed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(shipping); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"time"
 	"bytes"
 	"errors"
+	"context"
+	"strings"
 
+	"net/url"
 	"net/http"
 
 	"encoding/json"
@@ -240,27 +243,64 @@ func (api *Api) CarrierServiceDelete(shop, token, id string) (*CarrierServiceDel
 	return &graphql.Data.CarrierServiceDelete, nil
 }
 
-func parseDimension(m *Metafield) (float64, error) {
+func parseDimension(field string, m *Metafield) (float64, error) {
 	if m == nil || m.Value == "" {
-		return 0, fmt.Errorf("invalid metafield\n")
+		return 0, &DimensionError{Field: field, Message: "missing metafield"}
 	}
 
 	var dim Metric 
 	err := json.Unmarshal([]byte(m.Value), &dim)
 	if err != nil {
-		return 0, err
+		return 0, &DimensionError{Field: field, Message: "invalid metafield value"}
 	}
 
-	// TODO: Convert to the correct unit
-	if dim.Unit != "CENTIMETERS" {
-		return 0, fmt.Errorf("invalid dim unit %s\n", dim.Unit)
+	cm, err := ToCentimeters(dim)
+	if err != nil {
+		return 0, &DimensionError{Field: field, Message: err.Error()}
 	}
 
-	return dim.Value, nil
+	if cm <= 0 {
+		return 0, &DimensionError{Field: field, Message: "dimension must be greater than zero"}
+	}
 
+	return cm, nil
 } 
 
-func(api *Api) GetProductDimensions(shop, token, id string) (*DimensionCm, error) {
+func parseDimensions(largo, ancho, alto *Metafield) (*DimensionCm, error) {
+	l, err := parseDimension("largo", largo)
+	if err != nil {
+		return nil, err
+	}
+	
+	a, err := parseDimension("ancho", ancho)
+	if err != nil {
+		return nil, err
+	}
+	
+	h, err := parseDimension("alto", alto)
+	if err != nil {
+		return nil, err
+	}
+	
+	dim := &DimensionCm{
+		Width: h,
+		Height: a,
+		Length: l,
+	}
+
+	return dim, nil
+}
+
+// parseOptionalDimensions returns nil without error when none of the
+// metafields are set, variants usually inherit the product dimensions.
+func parseOptionalDimensions(largo, ancho, alto *Metafield) (*DimensionCm, error) {
+	if largo == nil && ancho == nil && alto == nil {
+		return nil, nil
+	}
+	return parseDimensions(largo, ancho, alto)
+}
+
+func(api *Api) GetProductDimensions(ctx context.Context, shop, token, id string) (*DimensionCm, error) {
 	type GraphQLVariables struct {
 		OwnerID string `json:"ownerId"`
 	}
@@ -299,7 +339,7 @@ func(api *Api) GetProductDimensions(shop, token, id string) (*DimensionCm, error
 	}
 
 	url := "https://"+shop+"/admin/api/2025-10/graphql.json" 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -326,28 +366,117 @@ func(api *Api) GetProductDimensions(shop, token, id string) (*DimensionCm, error
 		return nil, err
 	}
 	
-	largo, err := parseDimension(graphql.Data.Product.Largo)
-	if err != nil {
-		return nil, err
+	product := graphql.Data.Product
+	return parseDimensions(product.Largo, product.Ancho, product.Alto)
+}
+
+type Variant struct {
+	ProductID       int64
+	Dimensions      *DimensionCm
+	DimensionsError error
+	Product         *DimensionCm
+	ProductError    error
+	Grams           *int64
+}
+
+// GetVariantDimensions returns the dimension metafields of a variant and of
+// its product in one request, invalid metafields are reported in the
+// DimensionsError and ProductError fields.
+func (api *Api) GetVariantDimensions(ctx context.Context, shop, token, id string) (*Variant, error) {
+	type GraphQLVariables struct {
+		OwnerID string `json:"ownerId"`
 	}
-	
-	ancho, err := parseDimension(graphql.Data.Product.Ancho)
-	if err != nil {
-		return nil, err
+
+	type GraphQLPayload struct {
+		Query     string           `json:"query"`
+		Variables GraphQLVariables `json:"variables"`
 	}
-	
-	alto, err := parseDimension(graphql.Data.Product.Alto)
-	if err != nil {
+
+	query := `
+		query VariantMetafields($ownerId: ID!) {
+		    productVariant(id: $ownerId) {
+		        largo: metafield(namespace: "custom", key: "largo") {
+		          value
+		        }
+		        ancho: metafield(namespace: "custom", key: "ancho") {
+		          value
+		        }
+		        alto: metafield(namespace: "custom", key: "alto") {
+		          value
+		        }
+		        inventoryItem {
+		          measurement {
+		            weight {
+		              unit
+		              value
+		            }
+		          }
+		        }
+		        product {
+		          id
+		          largo: metafield(namespace: "custom", key: "largo") {
+		            value
+		          }
+		          ancho: metafield(namespace: "custom", key: "ancho") {
+		            value
+		          }
+		          alto: metafield(namespace: "custom", key: "alto") {
+		            value
+		          }
+		        }
+		    }
+		}
+	`
+
+	payload := GraphQLPayload{
+		Query: query,
+		Variables: GraphQLVariables{
+			OwnerID: id,
+		},
+	}
+
+	var graphql struct {
+		Data struct {
+			ProductVariant *struct {
+				Largo         *Metafield `json:"largo"`
+				Ancho         *Metafield `json:"ancho"`
+				Alto          *Metafield `json:"alto"`
+				InventoryItem struct {
+					Measurement struct {
+						Weight *Metric `json:"weight"`
+					} `json:"measurement"`
+				} `json:"inventoryItem"`
+				Product struct {
+					ID    string     `json:"id"`
+					Largo *Metafield `json:"largo"`
+					Ancho *Metafield `json:"ancho"`
+					Alto  *Metafield `json:"alto"`
+				} `json:"product"`
+			} `json:"productVariant"`
+		} `json:"data"`
+	}
+
+	if err := api.graphqlContext(ctx, shop, token, &payload, &graphql); err != nil {
 		return nil, err
 	}
-	
-	dim := &DimensionCm{
-		Width: alto,
-		Height: ancho,
-		Length: largo,
+
+	v := graphql.Data.ProductVariant
+	if v == nil {
+		return nil, fmt.Errorf("variant %s not found", id)
 	}
 
-	return dim, nil
+	variant := &Variant{
+		ProductID: legacyID(v.Product.ID),
+	}
+	variant.Dimensions, variant.DimensionsError = parseOptionalDimensions(v.Largo, v.Ancho, v.Alto)
+	variant.Product, variant.ProductError = parseDimensions(v.Product.Largo, v.Product.Ancho, v.Product.Alto)
+
+	if weight := v.InventoryItem.Measurement.Weight; weight != nil && weight.Value > 0 {
+		grams := metricToGrams(weight)
+		variant.Grams = &grams
+	}
+
+	return variant, nil
 }
 
 type Address struct {
@@ -385,6 +514,10 @@ type AssignedLocation struct {
 	} `json:"location"`
 }
 
+func (l *AssignedLocation) LegacyID() int64 {
+	return legacyID(l.Location.ID)
+}
+
 type FulfillmentOrders struct {
 	Nodes []struct {
 		ID               string   				 `json:"id"`
@@ -500,3 +633,65 @@ func (api *Api) GetFulfillments(shop, token, orderID string) (*FulfillmentOrders
 	return &graphql.Data.Order.FulfillmentOrders, nil
 }
 
+
+func nextPageUrl(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		sections := strings.Split(part, ";")
+		if len(sections) < 2 {
+			continue
+		}
+		if strings.TrimSpace(sections[1]) != `rel="next"` {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(sections[0]), "<>")
+	}
+	return ""
+}
+
+func (api *Api) GetOrdersUpdatedSince(shop, token string, since time.Time) ([]Order, error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   shop,
+		Path:   "/admin/api/2025-10/orders.json",
+	}
+	q := u.Query()
+	q.Set("status", "any")
+	q.Set("limit", "250")
+	q.Set("updated_at_min", since.UTC().Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+
+	orders := []Order{}
+	next := u.String()
+
+	for next != "" {
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Shopify-Access-Token", token)
+
+		resp, err := api.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New("shopify get orders failed")
+		}
+
+		var page struct {
+			Orders []Order `json:"orders"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, page.Orders...)
+		next = nextPageUrl(resp.Header.Get("Link"))
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,561 @@
+package shopify
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"bufio"
+	"errors"
+	"context"
+	"strings"
+	"strconv"
+
+	"net/http"
+
+	"encoding/json"
+
+	"tomi/src/database"
+)
+
+type BulkOperation struct {
+	ID             string  `json:"id"`
+	Status         string  `json:"status"`
+	ErrorCode      *string `json:"errorCode"`
+	ObjectCount    string  `json:"objectCount"`
+	Url            *string `json:"url"`
+	PartialDataUrl *string `json:"partialDataUrl"`
+}
+
+func (op *BulkOperation) Finished() bool {
+	switch op.Status {
+	case "COMPLETED", "CANCELED", "FAILED", "EXPIRED":
+		return true
+	}
+	return false
+}
+
+func (api *Api) graphql(shop, token string, payload any, result any) error {
+	return api.graphqlContext(context.Background(), shop, token, payload, result)
+}
+
+// graphqlContext is graphql bound to ctx, for the requests made while
+// answering shopify.
+func (api *Api) graphqlContext(ctx context.Context, shop, token string, payload any, result any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	url := "https://"+shop+"/admin/api/2025-10/graphql.json"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type",  "application/json")
+	req.Header.Set("X-Shopify-Access-Token", token)
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("shopify graphql request failed: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (api *Api) BulkOperationRunQuery(shop, token, query string) (*BulkOperation, error) {
+	type GraphQLVariables struct {
+		Query string `json:"query"`
+	}
+
+	type GraphQLPayload struct {
+		Query     string           `json:"query"`
+		Variables GraphQLVariables `json:"variables"`
+	}
+
+	payload := GraphQLPayload{
+		Query: "mutation BulkOperationRunQuery($query: String!) { bulkOperationRunQuery(query: $query) { bulkOperation { id status } userErrors { field message } } }",
+		Variables: GraphQLVariables{
+			Query: query,
+		},
+	}
+
+	var graphql struct {
+		Data struct {
+			BulkOperationRunQuery struct {
+				BulkOperation *BulkOperation `json:"bulkOperation"`
+				UserErrors    []UserError    `json:"userErrors"`
+			} `json:"bulkOperationRunQuery"`
+		} `json:"data"`
+	}
+	if err := api.graphql(shop, token, &payload, &graphql); err != nil {
+		return nil, err
+	}
+
+	result := graphql.Data.BulkOperationRunQuery
+	if len(result.UserErrors) > 0 {
+		return nil, fmt.Errorf("shopify bulk operation failed: %s", result.UserErrors[0].Message)
+	}
+	if result.BulkOperation == nil {
+		return nil, errors.New("shopify bulk operation failed")
+	}
+
+	return result.BulkOperation, nil
+}
+
+func (api *Api) GetBulkOperation(shop, token, id string) (*BulkOperation, error) {
+	type GraphQLVariables struct {
+		ID string `json:"id"`
+	}
+
+	type GraphQLPayload struct {
+		Query     string           `json:"query"`
+		Variables GraphQLVariables `json:"variables"`
+	}
+
+	payload := GraphQLPayload{
+		Query: "query BulkOperation($id: ID!) { node(id: $id) { ... on BulkOperation { id status errorCode objectCount url partialDataUrl } } }",
+		Variables: GraphQLVariables{
+			ID: id,
+		},
+	}
+
+	var graphql struct {
+		Data struct {
+			Node *BulkOperation `json:"node"`
+		} `json:"data"`
+	}
+	if err := api.graphql(shop, token, &payload, &graphql); err != nil {
+		return nil, err
+	}
+
+	if graphql.Data.Node == nil {
+		return nil, fmt.Errorf("bulk operation %s not found", id)
+	}
+
+	return graphql.Data.Node, nil
+}
+
+// The result files can be large so they are not bound to the api timeout,
+// only to a longer one for the whole download.
+const bulkDownloadTimeout = 30 * time.Minute
+
+var bulkDownloadClient = &http.Client{Timeout: bulkDownloadTimeout}
+
+// DownloadBulkOperation streams the JSONL result of a finished bulk operation
+// calling fn once per line.
+func DownloadBulkOperation(url string, fn func(line []byte) error) error {
+	resp, err := bulkDownloadClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("shopify bulk operation download failed")
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func legacyID(gid string) int64 {
+	i := strings.LastIndex(gid, "/")
+	id, err := strconv.ParseInt(gid[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+type BulkMoneyBag struct {
+	ShopMoney struct {
+		Amount       string `json:"amount"`
+		CurrencyCode string `json:"currencyCode"`
+	} `json:"shopMoney"`
+}
+
+func (b BulkMoneyBag) toMoneyBag() MoneyBag {
+	return MoneyBag{
+		ShopMoney: Money{
+			Amount:       b.ShopMoney.Amount,
+			CurrencyCode: b.ShopMoney.CurrencyCode,
+		},
+	}
+}
+
+type BulkLineItem struct {
+	ID                   string       `json:"id"`
+	Name                 string       `json:"name"`
+	Sku                  *string      `json:"sku"`
+	CurrentQuantity      int64        `json:"currentQuantity"`
+	OriginalUnitPriceSet BulkMoneyBag `json:"originalUnitPriceSet"`
+	Product              *struct {
+		ID string `json:"id"`
+	} `json:"product"`
+	Variant              *struct {
+		ID            string `json:"id"`
+		InventoryItem struct {
+			Measurement struct {
+				Weight *Metric `json:"weight"`
+			} `json:"measurement"`
+		} `json:"inventoryItem"`
+	} `json:"variant"`
+	ParentID             string       `json:"__parentId"`
+}
+
+type BulkOrder struct {
+	ID                       string        `json:"id"`
+	CurrencyCode             string        `json:"currencyCode"`
+	Email                    *string       `json:"email"`
+	CurrentSubtotalPriceSet  BulkMoneyBag  `json:"currentSubtotalPriceSet"`
+	CurrentShippingPriceSet  BulkMoneyBag  `json:"currentShippingPriceSet"`
+	CurrentTotalPriceSet     BulkMoneyBag  `json:"currentTotalPriceSet"`
+	CurrentTotalDiscountsSet BulkMoneyBag  `json:"currentTotalDiscountsSet"`
+	DisplayFinancialStatus   string        `json:"displayFinancialStatus"`
+	DisplayFulfillmentStatus string        `json:"displayFulfillmentStatus"`
+	CancelledAt              *string       `json:"cancelledAt"`
+	UpdatedAt                string        `json:"updatedAt"`
+	ShippingAddress          *struct {
+		FirstName *string `json:"firstName"`
+		LastName  *string `json:"lastName"`
+		Name      *string `json:"name"`
+		Address1  *string `json:"address1"`
+		Address2  *string `json:"address2"`
+		Phone     *string `json:"phone"`
+		City      *string `json:"city"`
+		Zip       *string `json:"zip"`
+		Province  *string `json:"province"`
+		Country   *string `json:"country"`
+	} `json:"shippingAddress"`
+	ShippingLine             *struct {
+		Source           *string      `json:"source"`
+		Code             *string      `json:"code"`
+		OriginalPriceSet BulkMoneyBag `json:"originalPriceSet"`
+	} `json:"shippingLine"`
+
+	LineItems []BulkLineItem `json:"-"`
+}
+
+const OrdersBulkQuery = `
+{
+  orders(query: "updated_at:>='%s'") {
+    edges {
+      node {
+        id
+        currencyCode
+        email
+        updatedAt
+        cancelledAt
+        displayFinancialStatus
+        displayFulfillmentStatus
+        currentSubtotalPriceSet { shopMoney { amount currencyCode } }
+        currentShippingPriceSet { shopMoney { amount currencyCode } }
+        currentTotalPriceSet { shopMoney { amount currencyCode } }
+        currentTotalDiscountsSet { shopMoney { amount currencyCode } }
+        shippingAddress {
+          firstName
+          lastName
+          name
+          address1
+          address2
+          phone
+          city
+          zip
+          province
+          country
+        }
+        shippingLine {
+          source
+          code
+          originalPriceSet { shopMoney { amount currencyCode } }
+        }
+        lineItems {
+          edges {
+            node {
+              id
+              name
+              sku
+              currentQuantity
+              originalUnitPriceSet { shopMoney { amount currencyCode } }
+              product { id }
+              variant {
+                id
+                inventoryItem { measurement { weight { unit value } } }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+func (o *BulkOrder) IsPaid() bool {
+	return o.DisplayFinancialStatus == "PAID"
+}
+
+func (o *BulkOrder) IsFulfilled() bool {
+	return o.DisplayFulfillmentStatus == "FULFILLED"
+}
+
+func (o *BulkOrder) IsCancelled() bool {
+	return o.CancelledAt != nil
+}
+
+func (o *BulkOrder) ToDatabaseOrder(shop string) database.Order {
+	orderID := legacyID(o.ID)
+
+	var carrierName  *string = nil
+	var carrierCode  *string = nil
+	var carrierPrice int64  = 0
+
+	line := o.ShippingLine
+	if line != nil && line.Source != nil && line.Code != nil {
+		if *line.Source == os.Getenv("ANDREANI_CARRIER_NAME") {
+			carrierName = line.Source
+			carrierCode = line.Code
+			carrierPrice = getShopMoney(line.OriginalPriceSet.toMoneyBag())
+		}
+	}
+
+	var address *database.Address = nil
+	if o.ShippingAddress != nil {
+		address = &database.Address{
+			OrderID: &orderID,
+			Email: o.Email,
+			Phone: o.ShippingAddress.Phone,
+			Name: o.ShippingAddress.Name,
+			LastName: o.ShippingAddress.LastName,
+			Address1: o.ShippingAddress.Address1,
+			Address2: o.ShippingAddress.Address2,
+			Number: nil,
+			City: o.ShippingAddress.City,
+			Zip: o.ShippingAddress.Zip,
+			Province: o.ShippingAddress.Province,
+			Country: o.ShippingAddress.Country,
+		}
+	}
+
+	items := []database.OrderItem{}
+	for _, lineItem := range o.LineItems {
+		item := database.OrderItem{
+			ItemID: legacyID(lineItem.ID),
+			ItemApiID: lineItem.ID,
+			OrderID: orderID,
+			Name: lineItem.Name,
+			Quantity: lineItem.CurrentQuantity,
+			Currency: lineItem.OriginalUnitPriceSet.ShopMoney.CurrencyCode,
+			Price: getShopMoney(lineItem.OriginalUnitPriceSet.toMoneyBag()),
+		}
+		if lineItem.Sku != nil {
+			item.Sku = *lineItem.Sku
+		}
+		if lineItem.Product != nil {
+			item.ProductID = legacyID(lineItem.Product.ID)
+		}
+		if lineItem.Variant != nil {
+			variantID := legacyID(lineItem.Variant.ID)
+			item.VariantID = &variantID
+			item.Grams = metricToGrams(lineItem.Variant.InventoryItem.Measurement.Weight)
+		}
+		items = append(items, item)
+	}
+
+	updatedAt, _ := time.Parse(time.RFC3339, o.UpdatedAt)
+
+	return database.Order{
+		OrderID: orderID,
+		OrderApiID: o.ID,
+		Shop: shop,
+		Currency: o.CurrencyCode,
+		SubtotalPrice: getShopMoney(o.CurrentSubtotalPriceSet.toMoneyBag()),
+		ShippingPrice: getShopMoney(o.CurrentShippingPriceSet.toMoneyBag()),
+		Discount: getShopMoney(o.CurrentTotalDiscountsSet.toMoneyBag()),
+		TotalPrice: getShopMoney(o.CurrentTotalPriceSet.toMoneyBag()),
+		CarrierName: carrierName,
+		CarrierCode: carrierCode,
+		CarrierPrice: &carrierPrice,
+		ShippingAddress: address,
+		Items: items,
+		UpdatedAt: updatedAt,
+	}
+}
+
+// ErrOrphanBulkLine is returned for a line item that doesn't follow its
+// order in a bulk operation result.
+var ErrOrphanBulkLine = errors.New("bulk line item out of order")
+
+// ReadBulkOrders downloads the result of an OrdersBulkQuery operation and
+// calls fn for every order once all of its line items were read. Shopify
+// writes the line items right after their order, so only the order being
+// read is kept in memory. A line item that doesn't follow its order
+// stops the read with ErrOrphanBulkLine.
+func ReadBulkOrders(url string, fn func(order *BulkOrder) error) error {
+	var pending *BulkOrder
+
+	err := DownloadBulkOperation(url, func(line []byte) error {
+		var node struct {
+			ID       string `json:"id"`
+			ParentID string `json:"__parentId"`
+		}
+		if err := json.Unmarshal(line, &node); err != nil {
+			return err
+		}
+
+		if node.ParentID == "" {
+			if pending != nil {
+				if err := fn(pending); err != nil {
+					return err
+				}
+			}
+			pending = &BulkOrder{}
+			return json.Unmarshal(line, pending)
+		}
+
+		// An order already handed to fn would be missing this item, the
+		// file is truncated or not in the order this reader relies on.
+		if pending == nil || pending.ID != node.ParentID {
+			return fmt.Errorf("%w: line %s of order %s", ErrOrphanBulkLine, node.ID, node.ParentID)
+		}
+		item := BulkLineItem{}
+		if err := json.Unmarshal(line, &item); err != nil {
+			return err
+		}
+		pending.LineItems = append(pending.LineItems, item)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if pending != nil {
+		return fn(pending)
+	}
+
+	return nil
+}
+
+type BulkProduct struct {
+	ID    string     `json:"id"`
+	Largo *Metafield `json:"largo"`
+	Ancho *Metafield `json:"ancho"`
+	Alto  *Metafield `json:"alto"`
+}
+
+type BulkVariant struct {
+	ID            string     `json:"id"`
+	Largo         *Metafield `json:"largo"`
+	Ancho         *Metafield `json:"ancho"`
+	Alto          *Metafield `json:"alto"`
+	InventoryItem struct {
+		Measurement struct {
+			Weight *Metric `json:"weight"`
+		} `json:"measurement"`
+	} `json:"inventoryItem"`
+	ParentID      string     `json:"__parentId"`
+}
+
+const ProductDimensionsBulkQuery = `
+{
+  products {
+    edges {
+      node {
+        id
+        largo: metafield(namespace: "custom", key: "largo") { value }
+        ancho: metafield(namespace: "custom", key: "ancho") { value }
+        alto: metafield(namespace: "custom", key: "alto") { value }
+        variants {
+          edges {
+            node {
+              id
+              largo: metafield(namespace: "custom", key: "largo") { value }
+              ancho: metafield(namespace: "custom", key: "ancho") { value }
+              alto: metafield(namespace: "custom", key: "alto") { value }
+              inventoryItem { measurement { weight { unit value } } }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+func (p *BulkProduct) ProductID() int64 {
+	return legacyID(p.ID)
+}
+
+func (p *BulkProduct) Dimensions() (*DimensionCm, error) {
+	return parseDimensions(p.Largo, p.Ancho, p.Alto)
+}
+
+func (v *BulkVariant) VariantID() int64 {
+	return legacyID(v.ID)
+}
+
+func (v *BulkVariant) ProductID() int64 {
+	return legacyID(v.ParentID)
+}
+
+// Dimensions returns nil when the variant has no dimensions of its own.
+func (v *BulkVariant) Dimensions() (*DimensionCm, error) {
+	return parseOptionalDimensions(v.Largo, v.Ancho, v.Alto)
+}
+
+func (v *BulkVariant) Grams() *int64 {
+	weight := v.InventoryItem.Measurement.Weight
+	if weight == nil || weight.Value <= 0 {
+		return nil
+	}
+	grams := metricToGrams(weight)
+	return &grams
+}
+
+// ReadBulkProducts streams the result of a ProductDimensionsBulkQuery
+// operation, variants are read after the product they belong to.
+func ReadBulkProducts(url string, onProduct func(product *BulkProduct) error, onVariant func(variant *BulkVariant) error) error {
+	return DownloadBulkOperation(url, func(line []byte) error {
+		var node struct {
+			ParentID string `json:"__parentId"`
+		}
+		if err := json.Unmarshal(line, &node); err != nil {
+			return err
+		}
+
+		if node.ParentID != "" {
+			variant := &BulkVariant{}
+			if err := json.Unmarshal(line, variant); err != nil {
+				return err
+			}
+			return onVariant(variant)
+		}
+
+		product := &BulkProduct{}
+		if err := json.Unmarshal(line, product); err != nil {
+			return err
+		}
+		return onProduct(product)
+	})
+}
@@ -0,0 +1,148 @@
+package shopify
+
+import (
+	"fmt"
+	"time"
+	"errors"
+)
+
+// Fulfillment event statuses accepted by fulfillmentEventCreate.
+const (
+	FulfillmentInTransit         = "IN_TRANSIT"
+	FulfillmentOutForDelivery    = "OUT_FOR_DELIVERY"
+	FulfillmentAttemptedDelivery = "ATTEMPTED_DELIVERY"
+	FulfillmentReadyForPickup    = "READY_FOR_PICKUP"
+	FulfillmentDelivered         = "DELIVERED"
+	FulfillmentFailure           = "FAILURE"
+)
+
+// FulfillmentCancelled is the status of a cancelled fulfillment.
+const FulfillmentCancelled = "CANCELLED"
+
+type Fulfillment struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"`
+	TrackingInfo []TrackingInfo `json:"trackingInfo"`
+}
+
+type TrackingInfo struct {
+	Company string `json:"company"`
+	Number  string `json:"number"`
+	Url     string `json:"url,omitempty"`
+}
+
+type FulfillmentEvent struct {
+	FulfillmentID string    `json:"fulfillmentId"`
+	Status        string    `json:"status"`
+	HappenedAt    time.Time `json:"happenedAt"`
+	Message       string    `json:"message,omitempty"`
+	City          string    `json:"city,omitempty"`
+}
+
+func (api *Api) FulfillmentEventCreate(shop, token string, event FulfillmentEvent) error {
+	type GraphQLVariables struct {
+		FulfillmentEvent FulfillmentEvent `json:"fulfillmentEvent"`
+	}
+
+	type GraphQLPayload struct {
+		Query     string           `json:"query"`
+		Variables GraphQLVariables `json:"variables"`
+	}
+
+	payload := GraphQLPayload{
+		Query: "mutation FulfillmentEventCreate($fulfillmentEvent: FulfillmentEventInput!) { fulfillmentEventCreate(fulfillmentEvent: $fulfillmentEvent) { fulfillmentEvent { id status } userErrors { field message } } }",
+		Variables: GraphQLVariables{
+			FulfillmentEvent: event,
+		},
+	}
+
+	var graphql struct {
+		Data struct {
+			FulfillmentEventCreate struct {
+				UserErrors []UserError `json:"userErrors"`
+			} `json:"fulfillmentEventCreate"`
+		} `json:"data"`
+	}
+	if err := api.graphql(shop, token, &payload, &graphql); err != nil {
+		return err
+	}
+
+	result := graphql.Data.FulfillmentEventCreate
+	if len(result.UserErrors) > 0 {
+		return fmt.Errorf("shopify fulfillment event failed: %s", result.UserErrors[0].Message)
+	}
+
+	return nil
+}
+
+// GetOrderFulfillments returns the fulfillments already created for the order.
+func (api *Api) GetOrderFulfillments(shop, token, orderID string) ([]Fulfillment, error) {
+	type GraphQLVariables struct {
+		OrderID string `json:"orderID"`
+	}
+
+	type GraphQLPayload struct {
+		Query     string           `json:"query"`
+		Variables GraphQLVariables `json:"variables"`
+	}
+
+	payload := GraphQLPayload{
+		Query: "query ($orderID: ID!) { order(id: $orderID) { fulfillments(first: 64) { id status trackingInfo(first: 8) { company number url } } } }",
+		Variables: GraphQLVariables{
+			OrderID: orderID,
+		},
+	}
+
+	var graphql struct {
+		Data struct {
+			Order *struct {
+				Fulfillments []Fulfillment `json:"fulfillments"`
+			} `json:"order"`
+		} `json:"data"`
+	}
+	if err := api.graphql(shop, token, &payload, &graphql); err != nil {
+		return nil, err
+	}
+
+	if graphql.Data.Order == nil {
+		return nil, errors.New("shopify order not found")
+	}
+
+	return graphql.Data.Order.Fulfillments, nil
+}
+
+func (api *Api) FulfillmentCancel(shop, token, fulfillmentID string) error {
+	type GraphQLVariables struct {
+		ID string `json:"id"`
+	}
+
+	type GraphQLPayload struct {
+		Query     string           `json:"query"`
+		Variables GraphQLVariables `json:"variables"`
+	}
+
+	payload := GraphQLPayload{
+		Query: "mutation FulfillmentCancel($id: ID!) { fulfillmentCancel(id: $id) { fulfillment { id status } userErrors { field message } } }",
+		Variables: GraphQLVariables{
+			ID: fulfillmentID,
+		},
+	}
+
+	var graphql struct {
+		Data struct {
+			FulfillmentCancel struct {
+				UserErrors []UserError `json:"userErrors"`
+			} `json:"fulfillmentCancel"`
+		} `json:"data"`
+	}
+	if err := api.graphql(shop, token, &payload, &graphql); err != nil {
+		return err
+	}
+
+	result := graphql.Data.FulfillmentCancel
+	if len(result.UserErrors) > 0 {
+		return fmt.Errorf("shopify fulfillment cancel failed: %s", result.UserErrors[0].Message)
+	}
+
+	return nil
+}
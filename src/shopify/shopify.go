@@ -2,11 +2,10 @@ package shopify
 
 import (
 	"os"
+	"log"
 	"time"
 	"sort"
 	"bytes"
-	"strings"
-	"strconv"
 	"errors"
 
 	"crypto/hmac"
@@ -19,6 +18,7 @@ import (
 	"encoding/json"
 	"encoding/base64"
 
+	"tomi/src/money"
 	"tomi/src/database"
 )
 
@@ -45,6 +45,10 @@ type Money struct {
 	CurrencyCode string `json:"currency_code"`
 }
 
+func (m Money) Parse() (money.Money, error) {
+	return money.Parse(m.Amount, m.CurrencyCode)
+}
+
 type MoneyBag struct {
 	PresentmentMoney Money `json:"presentment_money"`
 	ShopMoney        Money `json:"shop_money"`
@@ -85,34 +89,33 @@ type Order struct {
 	ShippingAddress          *MailingAddress `json:"shipping_address"`
 	ShippingLines            []ShippingLine  `json:"shipping_lines"`
 	LinesItems               []LineItem      `json:"line_items"`
+	FinancialStatus          *string         `json:"financial_status"`
+	FulfillmentStatus        *string         `json:"fulfillment_status"`
+	CancelledAt              *time.Time      `json:"cancelled_at"`
 	UpdatedAt                time.Time       `json:"updated_at"`
 }
 
-func getShopMoney(bag MoneyBag) int64 {
-	amount := bag.ShopMoney.Amount
+func (o *Order) IsPaid() bool {
+	return o.FinancialStatus != nil && *o.FinancialStatus == "paid"
+}
 
-	parts := strings.Split(amount, ".")
-	if len(parts) == 0 || len(parts) > 2 {
-		return 0
-	}
-	
-	if len(parts) == 1 {
-		i, err := strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			return 0
-		}
-		return i
-	}
-	
-	whole := parts[0]
-	frac := parts[1]
-	frac = frac[:2]
+func (o *Order) IsFulfilled() bool {
+	return o.FulfillmentStatus != nil && *o.FulfillmentStatus == "fulfilled"
+}
+
+func (o *Order) IsCancelled() bool {
+	return o.CancelledAt != nil
+}
 
-	i, err := strconv.ParseInt(whole+frac, 10, 64)
+// getShopMoney returns the shop currency amount in minor units, malformed
+// amounts are logged and counted as zero.
+func getShopMoney(bag MoneyBag) int64 {
+	m, err := bag.ShopMoney.Parse()
 	if err != nil {
+		log.Println(err.Error())
 		return 0
 	}
-	return i
+	return m.Amount
 }
 
 func (o *Order) ToDatabaseOrder(shop string) database.Order {
@@ -318,3 +321,30 @@ func (s *Api) OAuthRequestAccessToken(shop, code string) (*AccessTokenResponse,
 
 	return tokenResp, nil
 }
+
+type ProductMetafield struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+}
+
+type ProductWebhook struct {
+	ID                int64              `json:"id"`
+	AdminGraphqlApiID string             `json:"admin_graphql_api_id"`
+	Metafields        []ProductMetafield `json:"metafields"`
+}
+
+func (p *ProductWebhook) metafield(key string) *Metafield {
+	for _, m := range p.Metafields {
+		if m.Namespace == "custom" && m.Key == key {
+			return &Metafield{Value: m.Value}
+		}
+	}
+	return nil
+}
+
+// Dimensions reads the custom metafields included in the webhook payload,
+// they are only present when the subscription asks for the custom namespace.
+func (p *ProductWebhook) Dimensions() (*DimensionCm, error) {
+	return parseDimensions(p.metafield("largo"), p.metafield("ancho"), p.metafield("alto"))
+}
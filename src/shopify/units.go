@@ -0,0 +1,58 @@
+package shopify
+
+import (
+	"fmt"
+	"math"
+)
+
+type DimensionError struct {
+	Field   string
+	Message string
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+var centimetersPerUnit = map[string]float64{
+	"MILLIMETERS": 0.1,
+	"CENTIMETERS": 1,
+	"METERS":      100,
+	"INCHES":      2.54,
+	"FEET":        30.48,
+	"YARDS":       91.44,
+}
+
+var kilogramsPerUnit = map[string]float64{
+	"GRAMS":     0.001,
+	"KILOGRAMS": 1,
+	"OUNCES":    0.028349523125,
+	"POUNDS":    0.45359237,
+}
+
+func ToCentimeters(m Metric) (float64, error) {
+	factor, ok := centimetersPerUnit[m.Unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid dim unit %s", m.Unit)
+	}
+	return m.Value * factor, nil
+}
+
+func ToKilograms(m Metric) (float64, error) {
+	factor, ok := kilogramsPerUnit[m.Unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid weight unit %s", m.Unit)
+	}
+	return m.Value * factor, nil
+}
+
+func metricToGrams(m *Metric) int64 {
+	if m == nil {
+		return 0
+	}
+	kg, err := ToKilograms(*m)
+	if err != nil {
+		return 0
+	}
+	return int64(math.Round(kg * 1000))
+}
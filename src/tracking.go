@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"strconv"
+
+	"net/http"
+
+	"database/sql"
+	"encoding/json"
+
+	"tomi/src/andreani"
+	"tomi/src/database"
+)
+
+var andreaniDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// parseAndreaniDate reads Andreani dates, the ones without offset are local
+// to Argentina.
+func parseAndreaniDate(value string) (time.Time, error) {
+	for _, layout := range andreaniDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, argentina); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid andreani date " + strconv.Quote(value))
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// shippingNumbers returns the distinct numeroDeEnvio of the packages.
+func shippingNumbers(shipping *database.Shipping) []string {
+	numbers := []string{}
+	seen := map[string]bool{}
+	for _, pkg := range shipping.Packages {
+		if pkg.ShippingNumber == "" || seen[pkg.ShippingNumber] {
+			continue
+		}
+		seen[pkg.ShippingNumber] = true
+		numbers = append(numbers, pkg.ShippingNumber)
+	}
+	return numbers
+}
+
+// syncShipmentEvents stores the Andreani events of every package of the
+// shipping, returns the ones that were not stored yet and keeps the shipping
+// state on the latest event.
+func (app *Application) syncShipmentEvents(ctx context.Context, api *andreani.Api, shipping *database.Shipping) ([]database.ShipmentEvent, error) {
+	added := []database.ShipmentEvent{}
+	var latest *database.ShipmentEvent
+
+	for _, number := range shippingNumbers(shipping) {
+		events, err := api.GetTrackingEvents(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range events {
+			occurredAt, err := parseAndreaniDate(e.Fecha)
+			if err != nil {
+				log.Printf("shipping %d: %s\n", shipping.ShippingID, err.Error())
+				continue
+			}
+
+			event := database.ShipmentEvent{
+				ShippingID:     shipping.ShippingID,
+				ShippingNumber: number,
+				State:          e.Estado,
+				StateID:        e.EstadoId,
+				Reason:         optionalString(e.Motivo),
+				Branch:         optionalString(e.Sucursal),
+				OccurredAt:     occurredAt,
+			}
+
+			inserted, err := app.db.InsertShipmentEvent(&event)
+			if err != nil {
+				return nil, err
+			}
+			if inserted {
+				added = append(added, event)
+			}
+
+			if latest == nil || !event.OccurredAt.Before(latest.OccurredAt) {
+				e := event
+				latest = &e
+			}
+		}
+	}
+
+	// A shipping cancelled from the app keeps its state, Andreani traces
+	// rarely carry the cancel and would bring it back to life.
+	if latest != nil && latest.State != shipping.State && shipping.State != cancelledShipmentState {
+		if err := app.db.UpdateShippingState(shipping.ShippingID, latest.State); err != nil {
+			return nil, err
+		}
+		shipping.State = latest.State
+	}
+
+	return added, nil
+}
+
+// shipmentChanged asks Andreani for the current state of every package, a
+// call much cheaper than the traces, and reports whether any of them moved
+// past the last stored event.
+func (app *Application) shipmentChanged(ctx context.Context, api *andreani.Api, shipping *database.Shipping) (bool, error) {
+	events, err := app.db.GetShipmentEvents(shipping.ShippingID)
+	if err != nil {
+		return false, err
+	}
+
+	// Events are sorted, the last one of each number wins.
+	last := map[string]database.ShipmentEvent{}
+	for _, event := range events {
+		last[event.ShippingNumber] = event
+	}
+
+	for _, number := range shippingNumbers(shipping) {
+		stored, ok := last[number]
+		if !ok {
+			return true, nil
+		}
+
+		status, err := api.GetShipmentStatus(ctx, number)
+		if err != nil {
+			return false, err
+		}
+
+		if status.EstadoId != 0 && stored.StateID != 0 {
+			if status.EstadoId != stored.StateID {
+				return true, nil
+			}
+		} else if normalizeText(status.Estado) != normalizeText(stored.State) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// refreshShipmentEvents syncs the traces only when the package states
+// changed, returns the events that were not stored yet.
+func (app *Application) refreshShipmentEvents(ctx context.Context, api *andreani.Api, shipping *database.Shipping) ([]database.ShipmentEvent, error) {
+	changed, err := app.shipmentChanged(ctx, api, shipping)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return []database.ShipmentEvent{}, nil
+	}
+	return app.syncShipmentEvents(ctx, api, shipping)
+}
+
+// trackingRefreshInterval is how often the tracking endpoint may ask
+// Andreani for a given shipping, reads in between get the stored timeline.
+const trackingRefreshInterval = 5 * time.Minute
+
+// RefreshLimiter remembers when each shipping was last refreshed.
+type RefreshLimiter struct {
+	mu    sync.Mutex
+	every time.Duration
+	last  map[int64]time.Time
+}
+
+func NewRefreshLimiter(every time.Duration) *RefreshLimiter {
+	return &RefreshLimiter{
+		every: every,
+		last:  map[int64]time.Time{},
+	}
+}
+
+// Allow reports whether id can be refreshed now and, if so, records it.
+func (l *RefreshLimiter) Allow(id int64) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for other, at := range l.last {
+		if now.Sub(at) >= l.every {
+			delete(l.last, other)
+		}
+	}
+
+	if _, ok := l.last[id]; ok {
+		return false
+	}
+	l.last[id] = now
+	return true
+}
+
+// GetShipmentTrackingHandler refreshes the shipment events from Andreani, at
+// most once every trackingRefreshInterval per shipping, and returns the whole
+// timeline, the stored one when Andreani is unavailable.
+func (app *Application) GetShipmentTrackingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	shipping, err := app.db.GetShipping(app.shop, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "shipment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if app.trackingRefreshes.Allow(shipping.ShippingID) {
+		api, _, err := app.andreaniApi(app.shop)
+		if err != nil {
+			log.Println(err.Error())
+		} else if _, err := app.refreshShipmentEvents(r.Context(), api, shipping); err != nil {
+			log.Printf("shipping %d: tracking not refreshed: %s\n", shipping.ShippingID, err.Error())
+		}
+	}
+
+	events, err := app.db.GetShipmentEvents(shipping.ShippingID)
+	if err != nil {
+		log.Println(err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	tracking := struct {
+		ShippingID int64                    `json:"shipping_id"`
+		State      string                   `json:"state"`
+		Events     []database.ShipmentEvent `json:"events"`
+	}{
+		ShippingID: shipping.ShippingID,
+		State:      shipping.State,
+		Events:     events,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(tracking); err != nil {
+		log.Println("json encode error:", err.Error())
+	}
+}
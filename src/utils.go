@@ -1,27 +1,180 @@
 package main
 
 import (
+	"os"
+	"fmt"
+	"errors"
+	"context"
+	"regexp"
+	"strings"
+	"strconv"
 	"unicode"
 
+	"tomi/src/andreani"
+	"tomi/src/packing"
 	"tomi/src/shopify"
 )
 
 type PackageItem struct {
-	ProductID string
-	Quantity int
+	ProductID int64
+	VariantID int64
+	Grams     int64
+	Quantity  int
 }
 
-func calculatePackageVolumen(api *shopify.Api, token string, shop string, items []PackageItem) (float64, error) {
-	var totalVolumen float64 = 0
-	for _, item := range items {
-		dim, err := api.GetProductDimensions(shop, token, item.ProductID)
+// MissingDimensions is a cart item without usable dimensions nor shop
+// defaults to fall back on, Index is its position in the cart.
+type MissingDimensions struct {
+	Index     int
+	ProductID int64
+	VariantID int64
+	Reason    string
+}
+
+// MissingDimensionsError is returned when some items can't be measured,
+// packing them with no volume would under-declare the parcels.
+type MissingDimensionsError struct {
+	Items []MissingDimensions
+}
+
+func (e *MissingDimensionsError) Error() string {
+	products := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		products = append(products, fmt.Sprintf("%d (%s)", item.ProductID, item.Reason))
+	}
+	return "products without valid dimensions: " + strings.Join(products, ", ")
+}
+
+// packingItems resolves the dimensions and weight of every unit in the cart,
+// it stops with the ctx error once ctx is done.
+func (app *Application) packingItems(ctx context.Context, token string, shop string, items []PackageItem) ([]packing.Item, error) {
+	units := []packing.Item{}
+	missing := []MissingDimensions{}
+	for index, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		unit := packing.Item{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+		}
+
+		dim, err := app.itemDimensions(ctx, token, shop, item)
+		var dimErr *shopify.DimensionError
+		if errors.As(err, &dimErr) {
+			// Invalid products are listed in the dimension issues endpoint.
+			missing = append(missing, MissingDimensions{
+				Index:     index,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Reason:    dimErr.Error(),
+			})
+			continue
+		}
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		volumen := dim.Width * dim.Height * dim.Length
-		totalVolumen += volumen * float64(item.Quantity)
+
+		unit.Width = dim.Width
+		unit.Height = dim.Height
+		unit.Length = dim.Length
+		if unit.Volume() <= 0 {
+			missing = append(missing, MissingDimensions{
+				Index:     index,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Reason:    "dimensions are zero",
+			})
+			continue
+		}
+		unit.Kilos = float64(app.itemGrams(ctx, token, shop, item)) / 1000
+
+		for i := 0; i < item.Quantity; i++ {
+			units = append(units, unit)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, &MissingDimensionsError{Items: missing}
 	}
-	return totalVolumen, nil
+	return units, nil
+}
+
+// Andreani standard parcels, the contracts for heavier or larger ones set
+// PARCEL_MAX_SIDE_CM and PARCEL_MAX_KILOS.
+const (
+	defaultParcelMaxSide  = 150
+	defaultParcelMaxKilos = 50
+)
+
+func parcelLimits() packing.Limits {
+	limits := packing.Limits{
+		MaxSide:  defaultParcelMaxSide,
+		MaxKilos: defaultParcelMaxKilos,
+	}
+	if side, err := strconv.ParseFloat(os.Getenv("PARCEL_MAX_SIDE_CM"), 64); err == nil && side > 0 {
+		limits.MaxSide = side
+	}
+	if kilos, err := strconv.ParseFloat(os.Getenv("PARCEL_MAX_KILOS"), 64); err == nil && kilos > 0 {
+		limits.MaxKilos = kilos
+	}
+	return limits
+}
+
+// packItems turns the cart into Andreani parcels using the shop box catalog,
+// without boxes every item travels in a single parcel with the summed volume.
+// Items over the parcel limits fail with a *packing.OversizedError.
+func (app *Application) packItems(ctx context.Context, token string, shop string, items []PackageItem) ([]andreani.Bulto, error) {
+	units, err := app.packingItems(ctx, token, shop, items)
+	if err != nil {
+		return nil, err
+	}
+	limits := parcelLimits()
+
+	stored, err := app.db.GetBoxes(shop)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stored) == 0 {
+		bulto := andreani.Bulto{}
+		for _, unit := range units {
+			if err := limits.Check(unit); err != nil {
+				return nil, err
+			}
+			bulto.VolumenCm += unit.Volume()
+			bulto.Kilos += unit.Kilos
+		}
+		return []andreani.Bulto{bulto}, nil
+	}
+
+	boxes := make([]packing.Box, 0, len(stored))
+	for _, box := range stored {
+		boxes = append(boxes, packing.Box{
+			ID:        box.BoxID,
+			Name:      box.Name,
+			Width:     box.Width,
+			Height:    box.Height,
+			Length:    box.Length,
+			MaxKilos:  box.MaxKilos,
+			TareKilos: box.TareKilos,
+		})
+	}
+
+	parcels, err := packing.Pack(units, boxes, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	bultos := []andreani.Bulto{}
+	for _, parcel := range parcels {
+		bultos = append(bultos, andreani.Bulto{
+			Kilos:     parcel.Kilos,
+			VolumenCm: parcel.Volume(),
+		})
+	}
+	return bultos, nil
 }
 
 func onlyDigits(s string) string {
@@ -33,3 +186,28 @@ func onlyDigits(s string) string {
 	}
 	return string(b)
 }
+
+// cpaPattern matches the argentine CPA, the province letter, the 4 digit
+// postal code and the 3 letters of the block side, or the bare 4 digits.
+var cpaPattern = regexp.MustCompile(`^[A-Z]?([0-9]{4})([A-Z]{3})?$`)
+
+// postalCode returns the 4 digit code Andreani expects from a plain code or a
+// CPA like "C1425ABC", anything else keeps only its digits.
+func postalCode(zip string) string {
+	compact := strings.ToUpper(strings.Join(strings.Fields(zip), ""))
+	compact = strings.ReplaceAll(compact, "-", "")
+	if m := cpaPattern.FindStringSubmatch(compact); m != nil {
+		return m[1]
+	}
+	return onlyDigits(zip)
+}
+
+var accents = strings.NewReplacer(
+	"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+)
+
+// normalizeText lowers the text and strips the spanish accents to compare
+// free text coming from Andreani.
+func normalizeText(text string) string {
+	return accents.Replace(strings.ToLower(strings.TrimSpace(text)))
+}
@@ -56,6 +56,18 @@ type Event struct {
 }
 
 func (app *Application) OrdersWebhook(w http.ResponseWriter, r *http.Request) {
+	app.enqueueWebhook(w, r)
+}
+
+func (app *Application) BulkOperationsWebhook(w http.ResponseWriter, r *http.Request) {
+	app.enqueueWebhook(w, r)
+}
+
+func (app *Application) ProductsWebhook(w http.ResponseWriter, r *http.Request) {
+	app.enqueueWebhook(w, r)
+}
+
+func (app *Application) enqueueWebhook(w http.ResponseWriter, r *http.Request) {
 	// TODO: Verify shopify webhook
 	_ = r.Header.Get("X-Shopify-Hmac-Sha256")
 
@@ -152,6 +164,22 @@ func (app *Application) ProcessEvents() {
 				if err := app.OnCancelledOrderEvent(&order); err != nil {
 					log.Println(err)
 				}
+			case "products/create", "products/update":
+				payload := shopify.ProductWebhook{}
+				if err := json.Unmarshal(event.Body, &payload); err != nil {
+					continue
+				}
+				if err := app.OnProductEvent(event.Shop, &payload); err != nil {
+					log.Println(err)
+				}
+			case "bulk_operations/finish":
+				payload := struct {
+					ID string `json:"admin_graphql_api_id"`
+				}{}
+				if err := json.Unmarshal(event.Body, &payload); err != nil {
+					continue
+				}
+				go app.finishBulkOperation(payload.ID)
 		}
 	}
 }